@@ -2,12 +2,13 @@ package main
 
 import (
 	"fmt"
-	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/mikkeloscar/kube-node-ready-controller/pkg/providerid"
+	"k8s.io/api/core/v1"
 )
 
 const (
@@ -21,6 +22,20 @@ type Hook interface {
 	Trigger(providerID string) error
 }
 
+// NodeHook is a Hook which is triggered with the node rather than only its
+// provider ID.
+type NodeHook interface {
+	Hook
+	TriggerNode(node *v1.Node) error
+}
+
+// NotReadyHook is a Hook which is additionally called for every node which
+// is not ready on each pass.
+type NotReadyHook interface {
+	Hook
+	NotReady(node *v1.Node) error
+}
+
 // ASGLifecycleHook defines an ASG lifecycle hook to be triggered on node
 // Ready.
 type ASGLifecycleHook struct {
@@ -43,7 +58,7 @@ func (a *ASGLifecycleHook) Name() string {
 
 // Trigger triggers a the ASG lifecycle hook for a given instance.
 func (a *ASGLifecycleHook) Trigger(providerID string) error {
-	instanceID, err := instanceIDFromProviderID(providerID)
+	instanceID, err := providerid.EC2InstanceID(providerID)
 	if err != nil {
 		return err
 	}
@@ -74,15 +89,3 @@ func (a *ASGLifecycleHook) Trigger(providerID string) error {
 	_, err = a.svc.CompleteLifecycleAction(input)
 	return err
 }
-
-// instanceIDFromProviderID extracts the EC2 instanceID from a Kubernetes
-// ProviderID.
-func instanceIDFromProviderID(providerID string) (string, error) {
-	full := strings.TrimPrefix(providerID, "aws:///")
-	split := strings.Split(full, "/")
-	if len(split) != 2 {
-		return "", fmt.Errorf("unexpected providerID format: %s", providerID)
-	}
-
-	return split[1], nil
-}
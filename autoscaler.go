@@ -0,0 +1,116 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+)
+
+const (
+	// notReadyAnnotationsAnnotation lists the annotations added to a not
+	// ready node by the controller such that only those are removed once
+	// the node is ready.
+	notReadyAnnotationsAnnotation = "kube-node-ready-controller/not-ready-annotations"
+	// scaleDownDisabledAnnotation prevents cluster-autoscaler from scaling
+	// down the annotated node.
+	scaleDownDisabledAnnotation = "cluster-autoscaler.kubernetes.io/scale-down-disabled"
+	// doNotDisruptAnnotation prevents Karpenter from disrupting, e.g.
+	// consolidating, the annotated node.
+	doNotDisruptAnnotation = "karpenter.sh/do-not-disrupt"
+)
+
+// autoscalerAnnotations are the annotations set on not ready nodes such that
+// cluster-autoscaler and Karpenter don't scale down nodes which are still
+// starting up.
+var autoscalerAnnotations = map[string]string{
+	scaleDownDisabledAnnotation: "true",
+	doNotDisruptAnnotation:      "true",
+}
+
+// reconcileAnnotations adds the not ready annotations to a node which is not
+// ready and removes them once the node is ready. Annotations already set on
+// the node are left untouched and only the annotations added by the
+// controller are removed. It returns the keys of the added and removed
+// annotations.
+func reconcileAnnotations(node *v1.Node, annotations map[string]string, ready bool) (added, removed []string) {
+	var owned []string
+	if value := node.Annotations[notReadyAnnotationsAnnotation]; value != "" {
+		owned = strings.Split(value, ",")
+	}
+
+	if ready {
+		if _, ok := node.Annotations[notReadyAnnotationsAnnotation]; !ok {
+			return nil, nil
+		}
+
+		for _, key := range owned {
+			if _, ok := node.Annotations[key]; ok {
+				delete(node.Annotations, key)
+				removed = append(removed, key)
+			}
+		}
+		delete(node.Annotations, notReadyAnnotationsAnnotation)
+		return nil, removed
+	}
+
+	for key, value := range annotations {
+		if _, ok := node.Annotations[key]; ok {
+			continue
+		}
+
+		if node.Annotations == nil {
+			node.Annotations = make(map[string]string, len(annotations)+1)
+		}
+		node.Annotations[key] = value
+		added = append(added, key)
+	}
+
+	if len(added) == 0 {
+		return nil, nil
+	}
+
+	sort.Strings(added)
+	owned = append(owned, added...)
+	sort.Strings(owned)
+	node.Annotations[notReadyAnnotationsAnnotation] = strings.Join(owned, ",")
+	return added, nil
+}
+
+// notReadyAnnotationsExpired returns true if the not ready annotation timeout
+// has elapsed since the node was created. The annotations of a node which
+// never becomes ready are removed then such that autoscalers can scale it
+// down.
+func (n *NodeController) notReadyAnnotationsExpired(node *v1.Node, now time.Time) bool {
+	if n.notReadyAnnotationTimeout <= 0 || node.CreationTimestamp.IsZero() {
+		return false
+	}
+	return now.Sub(node.CreationTimestamp.Time) > n.notReadyAnnotationTimeout
+}
+
+// logAnnotationChanges logs the not ready annotations added to or removed
+// from the node.
+func logAnnotationChanges(node *v1.Node, added, removed []string, dryRun bool) {
+	addAction, removeAction := "annotated", "removed-annotation"
+	if dryRun {
+		addAction, removeAction = "would-annotate", "would-remove-annotation"
+	}
+
+	for _, key := range added {
+		log.WithFields(log.Fields{
+			"action":     addAction,
+			"annotation": key,
+			"node":       node.ObjectMeta.Name,
+		}).Info("")
+	}
+
+	for _, key := range removed {
+		log.WithFields(log.Fields{
+			"action":     removeAction,
+			"annotation": key,
+			"node":       node.ObjectMeta.Name,
+		}).Info("")
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReconcileAnnotations(t *testing.T) {
+	for _, tc := range []struct {
+		msg         string
+		annotations map[string]string
+		ready       bool
+		expected    map[string]string
+		added       []string
+		removed     []string
+	}{
+		{
+			msg:   "annotations should be added to not ready node",
+			ready: false,
+			expected: map[string]string{
+				scaleDownDisabledAnnotation:   "true",
+				doNotDisruptAnnotation:        "true",
+				notReadyAnnotationsAnnotation: scaleDownDisabledAnnotation + "," + doNotDisruptAnnotation,
+			},
+			added: []string{scaleDownDisabledAnnotation, doNotDisruptAnnotation},
+		},
+		{
+			msg:         "existing annotations should not be owned",
+			annotations: map[string]string{scaleDownDisabledAnnotation: "false"},
+			ready:       false,
+			expected: map[string]string{
+				scaleDownDisabledAnnotation:   "false",
+				doNotDisruptAnnotation:        "true",
+				notReadyAnnotationsAnnotation: doNotDisruptAnnotation,
+			},
+			added: []string{doNotDisruptAnnotation},
+		},
+		{
+			msg: "annotations should not be changed for annotated not ready node",
+			annotations: map[string]string{
+				scaleDownDisabledAnnotation:   "true",
+				doNotDisruptAnnotation:        "true",
+				notReadyAnnotationsAnnotation: scaleDownDisabledAnnotation + "," + doNotDisruptAnnotation,
+			},
+			ready: false,
+			expected: map[string]string{
+				scaleDownDisabledAnnotation:   "true",
+				doNotDisruptAnnotation:        "true",
+				notReadyAnnotationsAnnotation: scaleDownDisabledAnnotation + "," + doNotDisruptAnnotation,
+			},
+		},
+		{
+			msg: "only owned annotations should be removed from ready node",
+			annotations: map[string]string{
+				scaleDownDisabledAnnotation:   "false",
+				doNotDisruptAnnotation:        "true",
+				notReadyAnnotationsAnnotation: doNotDisruptAnnotation,
+			},
+			ready:    true,
+			expected: map[string]string{scaleDownDisabledAnnotation: "false"},
+			removed:  []string{doNotDisruptAnnotation},
+		},
+		{
+			msg:         "annotations should not be changed for ready node",
+			annotations: map[string]string{scaleDownDisabledAnnotation: "true"},
+			ready:       true,
+			expected:    map[string]string{scaleDownDisabledAnnotation: "true"},
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			added, removed := reconcileAnnotations(node, autoscalerAnnotations, tc.ready)
+			if !reflect.DeepEqual(node.Annotations, tc.expected) {
+				t.Errorf("expected annotations %v, got %v", tc.expected, node.Annotations)
+			}
+
+			if !reflect.DeepEqual(added, tc.added) || !reflect.DeepEqual(removed, tc.removed) {
+				t.Errorf("expected added %v and removed %v, got %v and %v", tc.added, tc.removed, added, removed)
+			}
+		})
+	}
+}
+
+func TestSetNodeReadyAnnotations(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "foo"}}
+	controller := &NodeController{
+		Interface:           setupMockKubernetes(t, node, nil),
+		notReadyAnnotations: autoscalerAnnotations,
+	}
+
+	for _, ready := range []bool{false, true} {
+		err := controller.setNodeReady(node, map[string]bool{taintNodeNotReadyName: ready}, nil)
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+
+		n, err := controller.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+
+		_, annotated := n.Annotations[scaleDownDisabledAnnotation]
+		if hasTaint(n, taintNodeNotReadyName) != annotated {
+			t.Errorf("expected node to be annotated while tainted, got taints %v and annotations %v", n.Spec.Taints, n.Annotations)
+		}
+	}
+}
+
+func TestSetNodeReadyAnnotationsExpired(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "foo",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-30 * time.Minute)),
+		},
+	}
+	controller := &NodeController{
+		Interface:                 setupMockKubernetes(t, node, nil),
+		notReadyAnnotations:       autoscalerAnnotations,
+		notReadyAnnotationTimeout: time.Hour,
+	}
+
+	err := controller.setNodeReady(node, map[string]bool{taintNodeNotReadyName: false}, nil)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	n, err := controller.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	if _, ok := n.Annotations[scaleDownDisabledAnnotation]; !ok {
+		t.Errorf("expected not ready node to be annotated before the timeout, got %v", n.Annotations)
+	}
+
+	// the node is still not ready after the timeout.
+	controller.notReadyAnnotationTimeout = 10 * time.Minute
+	err = controller.setNodeReady(node, map[string]bool{taintNodeNotReadyName: false}, nil)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	n, err = controller.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	for _, key := range []string{scaleDownDisabledAnnotation, doNotDisruptAnnotation, notReadyAnnotationsAnnotation} {
+		if _, ok := n.Annotations[key]; ok {
+			t.Errorf("expected annotation %s to be removed after the timeout, got %v", key, n.Annotations)
+		}
+	}
+
+	if !hasTaint(n, taintNodeNotReadyName) {
+		t.Error("expected node to stay tainted after the timeout")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mikkeloscar/kube-node-ready-controller/pkg/azure"
+	"github.com/mikkeloscar/kube-node-ready-controller/pkg/providerid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	azureVMTagHookName = "azure-vm-tag"
+)
+
+// AzureVMTagHook defines a hook setting a tag on the Azure VM of a node on
+// node Ready. This allows automation to tell when the VM is ready for
+// workloads. Instances of virtual machine scale sets don't support tags of
+// their own and are skipped by the hook.
+type AzureVMTagHook struct {
+	key     string
+	value   string
+	compute azure.ComputeAPI
+}
+
+// NewAzureVMTagHook creates a new Azure VM tag hook setting the tag key to
+// value.
+func NewAzureVMTagHook(compute azure.ComputeAPI, key, value string) *AzureVMTagHook {
+	return &AzureVMTagHook{
+		key:     key,
+		value:   value,
+		compute: compute,
+	}
+}
+
+// Name returns the hook name.
+func (h *AzureVMTagHook) Name() string {
+	return azureVMTagHookName
+}
+
+// Trigger merges the tag into the tags of the VM. Scale set instances are
+// skipped as only the scale set as a whole can be tagged.
+func (h *AzureVMTagHook) Trigger(providerID string) error {
+	instance, err := azureInstance(providerID)
+	if err != nil {
+		return err
+	}
+
+	if instance.ScaleSet != "" {
+		log.Debugf("Not tagging instance %s of scale set %s: tags are only supported for standalone VMs", instance.ID, instance.ScaleSet)
+		return nil
+	}
+
+	return h.compute.MergeTags(azure.ResourceID(instance), map[string]string{h.key: h.value})
+}
+
+// azureInstance parses the provider ID of an Azure VM.
+func azureInstance(providerID string) (*providerid.Instance, error) {
+	instance, err := providerid.Parse(providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if instance.Provider != providerid.Azure {
+		return nil, fmt.Errorf("expected %s provider, got %s", providerid.Azure, instance.Provider)
+	}
+	return instance, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/mikkeloscar/kube-node-ready-controller/pkg/azure"
+	"github.com/mikkeloscar/kube-node-ready-controller/pkg/providerid"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// AzureNodeStartUpObserver is a node startup duration observer which
+// determines the startup time duration based on the creation time of the
+// Azure VM or scale set instance.
+type AzureNodeStartUpObserver struct {
+	*instanceNodeStartUpObserver
+	compute    azure.ComputeAPI
+	newBackOff func() backoff.BackOff
+}
+
+// NewAzureNodeStartUpObserver registers a prometheus histogram vec with the
+// given buckets and returns an AzureNodeStartUpObserver. The histogram is
+// limited to maxSeries label combinations. Observed nodes are annotated
+// using the client.
+func NewAzureNodeStartUpObserver(compute azure.ComputeAPI, client kubernetes.Interface, buckets []float64, maxSeries int) (*AzureNodeStartUpObserver, error) {
+	startUpDurationSeconds, err := registerStartUpDurationHistogram(buckets)
+	if err != nil {
+		return nil, err
+	}
+
+	observer := newAzureNodeStartUpObserver(compute, client, startUpDurationSeconds, maxSeries)
+	go observer.run()
+	return observer, nil
+}
+
+// newAzureNodeStartUpObserver initializes a new AzureNodeStartUpObserver
+// without starting to process the queue.
+func newAzureNodeStartUpObserver(compute azure.ComputeAPI, client kubernetes.Interface, startUpDurationSeconds *prometheus.HistogramVec, maxSeries int) *AzureNodeStartUpObserver {
+	observer := &AzureNodeStartUpObserver{
+		compute:    compute,
+		newBackOff: newGetInstanceBackOff,
+	}
+	observer.instanceNodeStartUpObserver = newInstanceNodeStartUpObserver(startUpObserverAzure, observer.lookup, client, startUpDurationSeconds, maxSeries)
+	return observer
+}
+
+// lookup returns the creation time and the startup label values of the
+// Azure VM of the node. Throttled and failed requests are retried with
+// exponential backoff.
+func (o *AzureNodeStartUpObserver) lookup(node *v1.Node) (time.Time, []string, error) {
+	instance, err := azureInstance(node.Spec.ProviderID)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+
+	var vm *azure.VirtualMachine
+	getVirtualMachine := func() error {
+		var err error
+		vm, err = o.compute.GetVirtualMachine(azure.ResourceID(instance))
+		if apiErr, ok := err.(*azure.Error); ok && !retryableStatus(apiErr.StatusCode) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+
+	err = backoff.Retry(getVirtualMachine, o.newBackOff())
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+
+	return vm.Properties.TimeCreated, azureStartUpLabelValues(vm, instance, node), nil
+}
+
+// azureStartUpLabelValues returns the VM size, zone, scale set and lifecycle
+// of the node. The values are taken from the VM and fall back to the node
+// labels.
+func azureStartUpLabelValues(vm *azure.VirtualMachine, instance *providerid.Instance, node *v1.Node) []string {
+	vmSize := vm.VMSize()
+	if vmSize == "" {
+		vmSize = nodeLabel(node, instanceTypeNodeLabels)
+	}
+
+	zone := vm.Zone()
+	if zone == "" {
+		zone = nodeLabel(node, zoneNodeLabels)
+	}
+
+	nodeGroup := instance.ScaleSet
+	if nodeGroup == "" {
+		nodeGroup = nodeLabel(node, nodeGroupNodeLabels)
+	}
+
+	// the priority of scale set instances is only set on the scale set.
+	lifecycle := lifecycleOnDemand
+	if vm.Spot() || nodeLabel(node, lifecycleNodeLabels) == lifecycleSpot {
+		lifecycle = lifecycleSpot
+	}
+
+	return []string{vmSize, zone, nodeGroup, lifecycle}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/mikkeloscar/kube-node-ready-controller/pkg/azure"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	vmResourceID       = "/subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachines/"
+	scaleSetResourceID = "/subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachineScaleSets/pool/virtualMachines/"
+)
+
+type mockAzureCompute struct {
+	vms      map[string]*azure.VirtualMachine
+	throttle int
+	calls    int
+}
+
+func (c *mockAzureCompute) GetVirtualMachine(resourceID string) (*azure.VirtualMachine, error) {
+	c.calls++
+	if c.throttle > 0 {
+		c.throttle--
+		return nil, &azure.Error{StatusCode: http.StatusTooManyRequests, Code: "TooManyRequests"}
+	}
+
+	vm, ok := c.vms[resourceID]
+	if !ok {
+		return nil, &azure.Error{StatusCode: http.StatusNotFound, Code: "ResourceNotFound"}
+	}
+	return vm, nil
+}
+
+func (c *mockAzureCompute) MergeTags(resourceID string, tags map[string]string) error {
+	vm, ok := c.vms[resourceID]
+	if !ok {
+		return &azure.Error{StatusCode: http.StatusNotFound, Code: "ResourceNotFound"}
+	}
+
+	if vm.Tags == nil {
+		vm.Tags = make(map[string]string)
+	}
+	for k, v := range tags {
+		vm.Tags[k] = v
+	}
+	return nil
+}
+
+func TestAzureVMTagHook(t *testing.T) {
+	vm := &azure.VirtualMachine{Tags: map[string]string{"team": "a"}}
+	scaleSetVM := &azure.VirtualMachine{Tags: map[string]string{"team": "a"}}
+	compute := &mockAzureCompute{
+		vms: map[string]*azure.VirtualMachine{
+			vmResourceID + "vm-0":    vm,
+			scaleSetResourceID + "0": scaleSetVM,
+		},
+	}
+
+	hook := NewAzureVMTagHook(compute, "ready", "true")
+	err := hook.Trigger("azure://" + vmResourceID + "vm-0")
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	expected := map[string]string{"team": "a", "ready": "true"}
+	if !reflect.DeepEqual(vm.Tags, expected) {
+		t.Errorf("expected tags %v, got %v", expected, vm.Tags)
+	}
+
+	for _, providerID := range []string{"azure://" + vmResourceID + "vm-1", "gce://project/europe-west1-b/node-1"} {
+		err = hook.Trigger(providerID)
+		if err == nil {
+			t.Errorf("expected failure for %s", providerID)
+		}
+	}
+
+	// scale set instances can't be tagged individually and are skipped.
+	err = hook.Trigger("azure://" + scaleSetResourceID + "0")
+	if err != nil {
+		t.Errorf("should not fail for scale set instance: %s", err)
+	}
+
+	if _, ok := scaleSetVM.Tags["ready"]; ok {
+		t.Errorf("expected scale set instance to not be tagged, got %v", scaleSetVM.Tags)
+	}
+}
+
+func TestAzureNodeStartUpObserver(t *testing.T) {
+	now := time.Now().UTC()
+	node := func(name string) v1.Node {
+		return v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				CreationTimestamp: metav1.NewTime(now.Add(-time.Minute)),
+				Labels:            map[string]string{"kubernetes.azure.com/scalesetpriority": "spot"},
+			},
+			Spec: v1.NodeSpec{
+				ProviderID: "azure://" + scaleSetResourceID + name,
+			},
+		}
+	}
+
+	vm := &azure.VirtualMachine{Location: "westeurope", Zones: []string{"1"}}
+	vm.SKU.Name = "Standard_D4s_v3"
+	vm.Properties.TimeCreated = now.Add(-2 * time.Minute)
+	compute := &mockAzureCompute{
+		vms:      map[string]*azure.VirtualMachine{scaleSetResourceID + "0": vm},
+		throttle: 1,
+	}
+
+	a, b := node("0"), node("1")
+	observer := newAzureNodeStartUpObserver(
+		compute,
+		setupMockKubernetes(t, &a, nil),
+		prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "startup"}, startUpLabels),
+		10,
+	)
+	observer.newBackOff = func() backoff.BackOff {
+		return &backoff.ZeroBackOff{}
+	}
+
+	launchTime, labels, err := observer.lookup(&a)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	if !launchTime.Equal(vm.Properties.TimeCreated) {
+		t.Errorf("expected launch time %s, got %s", vm.Properties.TimeCreated, launchTime)
+	}
+
+	expected := []string{"Standard_D4s_v3", "westeurope-1", "pool", lifecycleSpot}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Errorf("expected labels %v, got %v", expected, labels)
+	}
+
+	observer.observe(NodeStartUp{Node: a, Ready: now})
+	observer.observe(NodeStartUp{Node: b, Ready: now})
+
+	if !observer.observed.observed(&a) || observer.observed.observed(&b) {
+		t.Error("expected only node 0 to be observed")
+	}
+
+	// throttled requests should be retried, not found vms not.
+	if compute.calls != 4 {
+		t.Errorf("expected 4 calls, got %d", compute.calls)
+	}
+}
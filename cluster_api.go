@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	clusterAPIGroup = "cluster.x-k8s.io"
+	// defaultClusterAPIVersion is the default version of the Cluster API
+	// Machine resource.
+	defaultClusterAPIVersion = "v1beta1"
+	// machineAnnotation and clusterNamespaceAnnotation are set on nodes by
+	// Cluster API to reference the Machine of the node.
+	machineAnnotation          = "cluster.x-k8s.io/machine"
+	clusterNamespaceAnnotation = "cluster.x-k8s.io/cluster-namespace"
+	// machineDeploymentLabel and machineSetLabel are set on Machines by
+	// Cluster API to reference the owning MachineDeployment and MachineSet.
+	machineDeploymentLabel = "cluster.x-k8s.io/deployment-name"
+	machineSetLabel        = "cluster.x-k8s.io/set-name"
+)
+
+// MachineClient describes a client for Cluster API Machines. Machines are
+// handled as unstructured objects so no dependency on Cluster API is needed.
+type MachineClient interface {
+	Get(namespace, name string) (*unstructured.Unstructured, error)
+	List() ([]unstructured.Unstructured, error)
+	UpdateStatus(machine *unstructured.Unstructured) error
+	Patch(namespace, name string, patch []byte) error
+}
+
+// dynamicMachineClient handles Machines via the dynamic client.
+type dynamicMachineClient struct {
+	machines dynamic.NamespaceableResourceInterface
+}
+
+// NewMachineClient initializes a new MachineClient for the given version of
+// the Machine resource using the dynamic client.
+func NewMachineClient(client dynamic.Interface, version string) MachineClient {
+	resource := schema.GroupVersionResource{
+		Group:    clusterAPIGroup,
+		Version:  version,
+		Resource: "machines",
+	}
+	return &dynamicMachineClient{machines: client.Resource(resource)}
+}
+
+// Get gets the Machine.
+func (c *dynamicMachineClient) Get(namespace, name string) (*unstructured.Unstructured, error) {
+	machine, err := c.machines.Namespace(namespace).Get(name, metav1.GetOptions{})
+	recordAPIError("get", err)
+	return machine, err
+}
+
+// List lists the Machines in all namespaces.
+func (c *dynamicMachineClient) List() ([]unstructured.Unstructured, error) {
+	list, err := c.machines.List(metav1.ListOptions{})
+	recordAPIError("list", err)
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// UpdateStatus updates the status of the Machine.
+func (c *dynamicMachineClient) UpdateStatus(machine *unstructured.Unstructured) error {
+	_, err := c.machines.Namespace(machine.GetNamespace()).UpdateStatus(machine, metav1.UpdateOptions{})
+	recordAPIError("update", err)
+	return err
+}
+
+// Patch applies a merge patch to the Machine.
+func (c *dynamicMachineClient) Patch(namespace, name string, patch []byte) error {
+	_, err := c.machines.Namespace(namespace).Patch(name, types.MergePatchType, patch, metav1.PatchOptions{})
+	recordAPIError("patch", err)
+	return err
+}
+
+// machineLookup finds the Machines of nodes. Machines looked up by provider
+// ID are found in an index of all Machines, which is built on the first lookup
+// after a reset such that Machines are listed at most once per pass.
+type machineLookup struct {
+	client MachineClient
+	mutex  sync.Mutex
+	index  map[string]*unstructured.Unstructured
+}
+
+// newMachineLookup initializes a new machineLookup using the client.
+func newMachineLookup(client MachineClient) *machineLookup {
+	return &machineLookup{client: client}
+}
+
+// machineForNode returns the Machine of the node. The Machine is referenced
+// by the annotations set on the node by Cluster API. If the node isn't
+// annotated, the Machine with the provider ID of the node is returned.
+func (l *machineLookup) machineForNode(node *v1.Node) (*unstructured.Unstructured, error) {
+	name := node.Annotations[machineAnnotation]
+	namespace := node.Annotations[clusterNamespaceAnnotation]
+	if name != "" && namespace != "" {
+		return l.client.Get(namespace, name)
+	}
+
+	return l.machineForProviderID(node.Spec.ProviderID)
+}
+
+// machineForProviderID returns the Machine with the provider ID.
+func (l *machineLookup) machineForProviderID(providerID string) (*unstructured.Unstructured, error) {
+	if providerID == "" {
+		return nil, fmt.Errorf("no machine reference and provider ID")
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.index == nil {
+		machines, err := l.client.List()
+		if err != nil {
+			return nil, err
+		}
+
+		l.index = make(map[string]*unstructured.Unstructured, len(machines))
+		for i, machine := range machines {
+			machineProviderID, _, _ := unstructured.NestedString(machine.Object, "spec", "providerID")
+			if machineProviderID != "" {
+				l.index[machineProviderID] = &machines[i]
+			}
+		}
+	}
+
+	machine, ok := l.index[providerID]
+	if !ok {
+		return nil, fmt.Errorf("no machine found with provider ID %s", providerID)
+	}
+	// the Machine is modified by the caller.
+	return machine.DeepCopy(), nil
+}
+
+// reset drops the index such that Machines are listed again on the next
+// lookup by provider ID.
+func (l *machineLookup) reset() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.index = nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	clusterAPIMachineHookName = "cluster-api-machine"
+	// machineNodeWorkloadReadyCondition is the condition set on the Machine
+	// once its node is marked ready.
+	machineNodeWorkloadReadyCondition = "NodeWorkloadReady"
+	// skipRemediationAnnotation makes MachineHealthChecks skip the
+	// remediation of the annotated Machine.
+	skipRemediationAnnotation = "cluster.x-k8s.io/skip-remediation"
+	// skipRemediationOwnerAnnotation marks the skip remediation annotation
+	// as added by the controller such that an annotation set by the user is
+	// never removed.
+	skipRemediationOwnerAnnotation = "kube-node-ready-controller/skip-remediation"
+)
+
+// ClusterAPIMachineHook defines a hook setting the NodeWorkloadReady
+// condition on the Cluster API Machine of a node on node Ready. Optionally
+// the remediation of the Machine by MachineHealthChecks is skipped while the
+// node is not ready until the skip remediation timeout has elapsed since the
+// node was created.
+type ClusterAPIMachineHook struct {
+	client                 MachineClient
+	machines               *machineLookup
+	skipRemediationTimeout time.Duration
+	// skipRemediation records if the skip remediation annotation was set
+	// (true) or removed (false) for the Machine of a node.
+	skipRemediation sync.Map
+	newBackOff      func() backoff.BackOff
+}
+
+// NewClusterAPIMachineHook creates a new Cluster API Machine hook. Remediation
+// is only skipped if skipRemediationTimeout is greater than 0.
+func NewClusterAPIMachineHook(client MachineClient, skipRemediationTimeout time.Duration) *ClusterAPIMachineHook {
+	return &ClusterAPIMachineHook{
+		client:                 client,
+		machines:               newMachineLookup(client),
+		skipRemediationTimeout: skipRemediationTimeout,
+		newBackOff: func() backoff.BackOff {
+			return backoff.WithMaxRetries(backoff.NewConstantBackOff(1*time.Second), maxConflictRetries)
+		},
+	}
+}
+
+// Name returns the hook name.
+func (h *ClusterAPIMachineHook) Name() string {
+	return clusterAPIMachineHookName
+}
+
+// Trigger sets the ready condition on the Machine with the provider ID.
+func (h *ClusterAPIMachineHook) Trigger(providerID string) error {
+	machine, err := h.machines.machineForProviderID(providerID)
+	if err != nil {
+		return err
+	}
+	return h.setReady(machine)
+}
+
+// TriggerNode sets the ready condition on the Machine of the node and stops
+// skipping its remediation.
+func (h *ClusterAPIMachineHook) TriggerNode(node *v1.Node) error {
+	machine, err := h.machines.machineForNode(node)
+	if err != nil {
+		return err
+	}
+
+	err = h.setReady(machine)
+	if err != nil {
+		return err
+	}
+
+	if h.skipRemediationTimeout > 0 {
+		err = h.patchSkipRemediation(machine, false)
+		if err != nil {
+			return err
+		}
+		h.skipRemediation.Delete(node.Name)
+	}
+	return nil
+}
+
+// NotReady skips the remediation of the Machine of the not ready node until
+// the skip remediation timeout has elapsed since the node was created.
+func (h *ClusterAPIMachineHook) NotReady(node *v1.Node) error {
+	if h.skipRemediationTimeout <= 0 {
+		return nil
+	}
+
+	skip := time.Since(node.CreationTimestamp.Time) < h.skipRemediationTimeout
+	if state, ok := h.skipRemediation.Load(node.Name); ok && state.(bool) == skip {
+		return nil
+	}
+
+	machine, err := h.machines.machineForNode(node)
+	if err != nil {
+		return err
+	}
+
+	err = h.patchSkipRemediation(machine, skip)
+	if err != nil {
+		return err
+	}
+	h.skipRemediation.Store(node.Name, skip)
+	return nil
+}
+
+// GarbageCollect forgets the remediation state of nodes which no longer
+// exist. As it's called once per pass, the Machines indexed by provider ID
+// are dropped as well.
+func (h *ClusterAPIMachineHook) GarbageCollect(nodes []v1.Node) {
+	h.machines.reset()
+
+	existing := make(map[string]struct{}, len(nodes))
+	for _, node := range nodes {
+		existing[node.Name] = struct{}{}
+	}
+
+	h.skipRemediation.Range(func(key, _ interface{}) bool {
+		if _, ok := existing[key.(string)]; !ok {
+			h.skipRemediation.Delete(key)
+		}
+		return true
+	})
+}
+
+// setReady sets the ready condition on the Machine. The status update is
+// retried on conflicts.
+func (h *ClusterAPIMachineHook) setReady(machine *unstructured.Unstructured) error {
+	namespace, name := machine.GetNamespace(), machine.GetName()
+	first := true
+
+	setCondition := func() error {
+		if !first {
+			var err error
+			machine, err = h.client.Get(namespace, name)
+			if err != nil {
+				return backoff.Permanent(err)
+			}
+		}
+		first = false
+
+		if !setMachineCondition(machine, time.Now().UTC()) {
+			return nil
+		}
+
+		err := h.client.UpdateStatus(machine)
+		if errors.IsConflict(err) {
+			return err
+		}
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		return nil
+	}
+
+	return backoff.Retry(setCondition, h.newBackOff())
+}
+
+// patchSkipRemediation sets or removes the skip remediation annotation of the
+// Machine. An annotation already set on the Machine is left untouched and only
+// an annotation added by the controller is removed.
+func (h *ClusterAPIMachineHook) patchSkipRemediation(machine *unstructured.Unstructured, skip bool) error {
+	annotations := machine.GetAnnotations()
+	_, exists := annotations[skipRemediationAnnotation]
+	_, owned := annotations[skipRemediationOwnerAnnotation]
+
+	// null values remove the annotations in a merge patch.
+	patchAnnotations := map[string]interface{}{
+		skipRemediationAnnotation:      nil,
+		skipRemediationOwnerAnnotation: nil,
+	}
+
+	switch {
+	case skip && !exists:
+		patchAnnotations[skipRemediationAnnotation] = ""
+		patchAnnotations[skipRemediationOwnerAnnotation] = "true"
+	case !skip && owned:
+	default:
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": patchAnnotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return h.client.Patch(machine.GetNamespace(), machine.GetName(), patch)
+}
+
+// setMachineCondition sets the NodeWorkloadReady condition of the Machine to
+// True. It returns false if the condition was already set.
+func setMachineCondition(machine *unstructured.Unstructured, now time.Time) bool {
+	conditions, _, _ := unstructured.NestedSlice(machine.Object, "status", "conditions")
+
+	condition := map[string]interface{}{
+		"type":               machineNodeWorkloadReadyCondition,
+		"status":             string(v1.ConditionTrue),
+		"lastTransitionTime": now.Format(time.RFC3339),
+		"reason":             "NodeReady",
+		"message":            "Node marked ready by " + controllerName,
+	}
+
+	found := false
+	for i, c := range conditions {
+		existing, ok := c.(map[string]interface{})
+		if !ok || existing["type"] != machineNodeWorkloadReadyCondition {
+			continue
+		}
+
+		if existing["status"] == string(v1.ConditionTrue) {
+			return false
+		}
+		conditions[i] = condition
+		found = true
+	}
+
+	if !found {
+		conditions = append(conditions, condition)
+	}
+
+	unstructured.SetNestedSlice(machine.Object, conditions, "status", "conditions")
+	return true
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ClusterAPINodeStartUpObserver is a node startup duration observer which
+// determines the startup time duration based on the creation time of the
+// Cluster API Machine of the node. It works with any infrastructure
+// provider.
+type ClusterAPINodeStartUpObserver struct {
+	*instanceNodeStartUpObserver
+	machines *machineLookup
+}
+
+// NewClusterAPINodeStartUpObserver registers a prometheus histogram vec with
+// the given buckets and returns a ClusterAPINodeStartUpObserver. The
+// histogram is limited to maxSeries label combinations. Observed nodes are
+// annotated using the client.
+func NewClusterAPINodeStartUpObserver(machines MachineClient, client kubernetes.Interface, buckets []float64, maxSeries int) (*ClusterAPINodeStartUpObserver, error) {
+	startUpDurationSeconds, err := registerStartUpDurationHistogram(buckets)
+	if err != nil {
+		return nil, err
+	}
+
+	observer := newClusterAPINodeStartUpObserver(machines, client, startUpDurationSeconds, maxSeries)
+	go observer.run()
+	return observer, nil
+}
+
+// newClusterAPINodeStartUpObserver initializes a new
+// ClusterAPINodeStartUpObserver without starting to process the queue.
+func newClusterAPINodeStartUpObserver(machines MachineClient, client kubernetes.Interface, startUpDurationSeconds *prometheus.HistogramVec, maxSeries int) *ClusterAPINodeStartUpObserver {
+	observer := &ClusterAPINodeStartUpObserver{machines: newMachineLookup(machines)}
+	observer.instanceNodeStartUpObserver = newInstanceNodeStartUpObserver(startUpObserverClusterAPI, observer.lookup, client, startUpDurationSeconds, maxSeries)
+	return observer
+}
+
+// lookup returns the creation time and the startup label values of the
+// Machine of the node.
+func (o *ClusterAPINodeStartUpObserver) lookup(node *v1.Node) (time.Time, []string, error) {
+	machine, err := o.machines.machineForNode(node)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+
+	return machine.GetCreationTimestamp().Time, machineStartUpLabelValues(machine, node), nil
+}
+
+// GarbageCollect forgets the observed nodes which no longer exist and drops
+// the Machines indexed by provider ID.
+func (o *ClusterAPINodeStartUpObserver) GarbageCollect(nodes []v1.Node) {
+	o.machines.reset()
+	o.instanceNodeStartUpObserver.GarbageCollect(nodes)
+}
+
+// machineStartUpLabelValues returns the instance type, zone, node group and
+// lifecycle of the node. The zone is the failure domain and the node group
+// the MachineDeployment (or MachineSet) of the Machine. The other values are
+// taken from the node labels.
+func machineStartUpLabelValues(machine *unstructured.Unstructured, node *v1.Node) []string {
+	zone, _, _ := unstructured.NestedString(machine.Object, "spec", "failureDomain")
+	if zone == "" {
+		zone = nodeLabel(node, zoneNodeLabels)
+	}
+
+	labels := machine.GetLabels()
+	nodeGroup := labels[machineDeploymentLabel]
+	if nodeGroup == "" {
+		nodeGroup = labels[machineSetLabel]
+	}
+	if nodeGroup == "" {
+		nodeGroup = nodeLabel(node, nodeGroupNodeLabels)
+	}
+
+	lifecycle := lifecycleOnDemand
+	if strings.ToLower(nodeLabel(node, lifecycleNodeLabels)) == lifecycleSpot {
+		lifecycle = lifecycleSpot
+	}
+
+	return []string{nodeLabel(node, instanceTypeNodeLabels), zone, nodeGroup, lifecycle}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+const machinePath = "/apis/cluster.x-k8s.io/v1beta1/namespaces/default/machines/machine-1"
+
+// mockMachineServer serves a single Machine. Status updates fail with a
+// conflict while conflicts is greater than 0. Only annotations are patched.
+type mockMachineServer struct {
+	machine   map[string]interface{}
+	conflicts int
+	patches   []string
+	lists     int
+}
+
+func (s *mockMachineServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == machinePath:
+		json.NewEncoder(w).Encode(s.machine)
+	case r.Method == http.MethodGet && r.URL.Path == "/apis/cluster.x-k8s.io/v1beta1/machines":
+		s.lists++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"apiVersion": "cluster.x-k8s.io/v1beta1",
+			"kind":       "MachineList",
+			"items":      []interface{}{s.machine},
+		})
+	case r.Method == http.MethodPut && r.URL.Path == machinePath+"/status":
+		if s.conflicts > 0 {
+			s.conflicts--
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Conflict","code":409}`)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&s.machine)
+		json.NewEncoder(w).Encode(s.machine)
+	case r.Method == http.MethodPatch && r.URL.Path == machinePath:
+		data, _ := ioutil.ReadAll(r.Body)
+		s.patches = append(s.patches, string(data))
+
+		var patch struct {
+			Metadata struct {
+				Annotations map[string]*string `json:"annotations"`
+			} `json:"metadata"`
+		}
+		json.Unmarshal(data, &patch)
+
+		machine := &unstructured.Unstructured{Object: s.machine}
+		annotations := machine.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		for key, value := range patch.Metadata.Annotations {
+			if value == nil {
+				delete(annotations, key)
+				continue
+			}
+			annotations[key] = *value
+		}
+		machine.SetAnnotations(annotations)
+		json.NewEncoder(w).Encode(s.machine)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+	}
+}
+
+func setupMachineClient(t *testing.T, handler http.Handler) (MachineClient, func()) {
+	server := httptest.NewServer(handler)
+	client, err := dynamic.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+	return NewMachineClient(client, defaultClusterAPIVersion), server.Close
+}
+
+func TestClusterAPIMachineHook(t *testing.T) {
+	server := &mockMachineServer{
+		machine: map[string]interface{}{
+			"apiVersion": "cluster.x-k8s.io/v1beta1",
+			"kind":       "Machine",
+			"metadata":   map[string]interface{}{"name": "machine-1", "namespace": "default"},
+			"spec":       map[string]interface{}{"providerID": "aws:///eu-central-1a/i-1234"},
+			"status": map[string]interface{}{
+				"conditions": []interface{}{
+					map[string]interface{}{"type": "Ready", "status": "True"},
+				},
+			},
+		},
+		conflicts: 1,
+	}
+	machines, stop := setupMachineClient(t, server)
+	defer stop()
+
+	hook := NewClusterAPIMachineHook(machines, time.Hour)
+	hook.newBackOff = func() backoff.BackOff {
+		return &backoff.ZeroBackOff{}
+	}
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "node-1",
+			CreationTimestamp: metav1.Now(),
+			Annotations: map[string]string{
+				machineAnnotation:          "machine-1",
+				clusterNamespaceAnnotation: "default",
+			},
+		},
+	}
+
+	// remediation should only be skipped once for a not ready node.
+	for i := 0; i < 2; i++ {
+		err := hook.NotReady(node)
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+	}
+
+	err := hook.TriggerNode(node)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	expectedPatches := []string{
+		`{"metadata":{"annotations":{"cluster.x-k8s.io/skip-remediation":"","kube-node-ready-controller/skip-remediation":"true"}}}`,
+		`{"metadata":{"annotations":{"cluster.x-k8s.io/skip-remediation":null,"kube-node-ready-controller/skip-remediation":null}}}`,
+	}
+	if !reflect.DeepEqual(server.patches, expectedPatches) {
+		t.Errorf("expected patches %v, got %v", expectedPatches, server.patches)
+	}
+
+	conditions, _, _ := unstructured.NestedSlice(server.machine, "status", "conditions")
+	if len(conditions) != 2 || conditions[1].(map[string]interface{})["type"] != machineNodeWorkloadReadyCondition {
+		t.Errorf("expected %s condition to be added, got %v", machineNodeWorkloadReadyCondition, conditions)
+	}
+
+	// the Machine should be found by provider ID.
+	err = hook.Trigger("aws:///eu-central-1a/i-1234")
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	err = hook.Trigger("aws:///eu-central-1a/i-5678")
+	if err == nil {
+		t.Error("expected failure")
+	}
+}
+
+func TestClusterAPIMachineHookUserSkipRemediation(t *testing.T) {
+	server := &mockMachineServer{
+		machine: map[string]interface{}{
+			"apiVersion": "cluster.x-k8s.io/v1beta1",
+			"kind":       "Machine",
+			"metadata": map[string]interface{}{
+				"name":        "machine-1",
+				"namespace":   "default",
+				"annotations": map[string]interface{}{skipRemediationAnnotation: ""},
+			},
+		},
+	}
+	machines, stop := setupMachineClient(t, server)
+	defer stop()
+
+	hook := NewClusterAPIMachineHook(machines, time.Hour)
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "node-1",
+			CreationTimestamp: metav1.Now(),
+			Annotations: map[string]string{
+				machineAnnotation:          "machine-1",
+				clusterNamespaceAnnotation: "default",
+			},
+		},
+	}
+
+	err := hook.NotReady(node)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	err = hook.TriggerNode(node)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	if len(server.patches) != 0 {
+		t.Errorf("expected skip remediation annotation set by the user to be left untouched, got patches %v", server.patches)
+	}
+
+	machine := &unstructured.Unstructured{Object: server.machine}
+	if _, ok := machine.GetAnnotations()[skipRemediationAnnotation]; !ok {
+		t.Error("expected skip remediation annotation set by the user to be kept")
+	}
+}
+
+func TestMachineLookup(t *testing.T) {
+	server := &mockMachineServer{
+		machine: map[string]interface{}{
+			"apiVersion": "cluster.x-k8s.io/v1beta1",
+			"kind":       "Machine",
+			"metadata":   map[string]interface{}{"name": "machine-1", "namespace": "default"},
+			"spec":       map[string]interface{}{"providerID": "aws:///eu-central-1a/i-1234"},
+		},
+	}
+	machines, stop := setupMachineClient(t, server)
+	defer stop()
+
+	lookup := newMachineLookup(machines)
+	node := func(providerID string) *v1.Node {
+		return &v1.Node{Spec: v1.NodeSpec{ProviderID: providerID}}
+	}
+
+	// Machines should be listed once per pass.
+	for i := 0; i < 2; i++ {
+		machine, err := lookup.machineForNode(node("aws:///eu-central-1a/i-1234"))
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+
+		if machine.GetName() != "machine-1" {
+			t.Errorf("expected machine-1, got %s", machine.GetName())
+		}
+	}
+
+	_, err := lookup.machineForNode(node("aws:///eu-central-1a/i-5678"))
+	if err == nil {
+		t.Error("expected failure")
+	}
+
+	if server.lists != 1 {
+		t.Errorf("expected machines to be listed once, got %d", server.lists)
+	}
+
+	lookup.reset()
+	_, err = lookup.machineForNode(node("aws:///eu-central-1a/i-1234"))
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	if server.lists != 2 {
+		t.Errorf("expected machines to be listed again after reset, got %d", server.lists)
+	}
+}
+
+func TestSetMachineCondition(t *testing.T) {
+	machine := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	now := time.Now().UTC()
+
+	if !setMachineCondition(machine, now) {
+		t.Error("expected condition to be set")
+	}
+
+	if setMachineCondition(machine, now) {
+		t.Error("expected condition to be set only once")
+	}
+}
+
+func TestMachineStartUpLabelValues(t *testing.T) {
+	machine := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]interface{}{machineSetLabel: "workers-abc"},
+		},
+		"spec": map[string]interface{}{"failureDomain": "eu-central-1a"},
+	}}
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+		"node.kubernetes.io/instance-type": "m5.large",
+		"node.kubernetes.io/lifecycle":     "spot",
+	}}}
+
+	labels := machineStartUpLabelValues(machine, node)
+	expected := []string{"m5.large", "eu-central-1a", "workers-abc", lifecycleSpot}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Errorf("expected labels %v, got %v", expected, labels)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config is the readiness configuration which can be defined in a config
+// map.
+type Config struct {
+	Selectors     []*PodSelector        `yaml:"selectors"`
+	Prerequisites *NodePrerequisites    `yaml:"nodePrerequisites"`
+	CSIDrivers    CSIDriverRequirements `yaml:"csiDrivers"`
+	Probes        []*Probe              `yaml:"probes"`
+	Images        *ImageRequirements    `yaml:"images"`
+	Checks        []checkConfig         `yaml:"checks"`
+	Stages        []stageConfig         `yaml:"stages"`
+	Taints        []stageConfig         `yaml:"taints"`
+}
+
+// ReadinessStages returns the readiness stages defined in the config in the
+// order they are evaluated. Taint groups are independent stages evaluated
+// first. Checks defined outside of stages form a final stage using the
+// default taint. The returned stages are validated as a whole, such that the
+// default stage can't conflict with the stages defined in the config.
+func (c *Config) ReadinessStages(defaultTaint string) ([]*Stage, error) {
+	stages := c.stages()
+
+	checks := c.ReadinessChecks()
+	if len(checks) > 0 || len(stages) == 0 {
+		stages = append(stages, &Stage{
+			Name:   defaultStageName,
+			Taint:  defaultTaint,
+			Checks: checks,
+		})
+	}
+
+	err := validateStages(stages)
+	if err != nil {
+		return nil, err
+	}
+
+	return stages, nil
+}
+
+// stages returns the taint groups and stages defined in the config.
+func (c *Config) stages() []*Stage {
+	stages := make([]*Stage, 0, len(c.Taints)+len(c.Stages)+1)
+	for _, group := range c.Taints {
+		stages = append(stages, group.taintGroup())
+	}
+
+	for _, stage := range c.Stages {
+		stages = append(stages, stage.stage())
+	}
+	return stages
+}
+
+// ReadinessChecks returns all readiness checks defined in the config.
+func (c *Config) ReadinessChecks() []ReadinessCheck {
+	var checks []ReadinessCheck
+	if c.Prerequisites != nil {
+		checks = append(checks, c.Prerequisites)
+	}
+
+	if len(c.CSIDrivers) > 0 {
+		checks = append(checks, &CSIDriversCheck{Drivers: c.CSIDrivers})
+	}
+
+	for _, probe := range c.Probes {
+		checks = append(checks, probe)
+	}
+
+	if c.Images != nil {
+		checks = append(checks, c.Images)
+	}
+
+	for _, selector := range c.Selectors {
+		checks = append(checks, selector)
+	}
+
+	for _, check := range c.Checks {
+		checks = append(checks, check.ReadinessCheck)
+	}
+
+	return checks
+}
+
+// ReadConfig reads a config defined as a yaml. Readiness checks can be
+// defined as a list of checks identified by their type:
+//
+// checks:
+// - type: pods
+//   namespace: kube-system
+//   labels:
+//     foo: bar
+// - type: nodePrerequisites
+//   conditions:
+//   - type: Ready
+// - type: csiDrivers
+//   drivers:
+//   - name: ebs.csi.aws.com
+// - type: httpProbe
+//   name: kube2iam
+//   port: 8181
+// - type: images
+//   references:
+//   - registry.example.org/app:v1
+//
+// The checks can be grouped into ordered stages each with its own taint. A
+// stage is only evaluated once all previous stages are ready:
+//
+// stages:
+// - name: network
+//   taint: example.org/network-not-ready
+//   checks:
+//   - type: pods
+//     namespace: kube-system
+//     labels:
+//       application: cni
+//
+// Independent taints, each with its own group of selectors and checks, can be
+// defined as taint groups. Their taints are removed independently of each
+// other and of the stages:
+//
+// taints:
+// - taint: example.org/gpu-not-ready
+//   selectors:
+//   - namespace: kube-system
+//     labels:
+//       application: nvidia-device-plugin
+//
+// Alternatively the checks can be defined per kind in the following format:
+//
+// selectors:
+// - namespace: kube-system
+//   labels:
+//     foo: bar
+//   readinessMode: condition
+//   requireRunning: true
+//   minReadySeconds: 10
+//   minReady: 2
+//   maxNotReady: 0
+// - name: logging-agent
+//   namespace: kube-system
+//   labels:
+//     application: logging-agent
+//   optional: true
+//   timeout: 5m
+// nodePrerequisites:
+//   conditions:
+//   - type: Ready
+//     status: "True"
+//   allocatable:
+//   - name: nvidia.com/gpu
+//     quantity: 1
+//   labels:
+//     foo: bar
+//   annotations:
+//     foo: ""
+// csiDrivers:
+// - name: ebs.csi.aws.com
+//   topologyKeys:
+//   - topology.ebs.csi.aws.com/zone
+// probes:
+// - name: kube2iam
+//   type: http
+//   target: node
+//   port: 8181
+//   path: /healthz
+//   expectedStatusCodes: [200]
+//   timeout: 2s
+// images:
+//   references:
+//   - registry.example.org/app:v1
+//   prePuller:
+//     namespace: kube-system
+//     labels:
+//       application: image-pre-puller
+func ReadConfig(data string) (*Config, error) {
+	var config Config
+	err := yaml.Unmarshal([]byte(data), &config)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, check := range config.ReadinessChecks() {
+		err := validateCheck(check)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = validateStages(config.stages())
+	if err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
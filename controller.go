@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff"
@@ -13,6 +16,9 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
@@ -21,13 +27,18 @@ const (
 	ConfigMapSelectorsKey   = "pod_selectors"
 	serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
 	maxConflictRetries      = 50
+	controllerName          = "kube-node-ready-controller"
+	// maxConcurrentNodes is the maximum number of nodes for which the
+	// readiness checks are evaluated concurrently.
+	maxConcurrentNodes = 10
 )
 
 // NodeController updates the readiness taint of nodes based on expected
 // resources defined by selectors.
 type NodeController struct {
 	kubernetes.Interface
-	selectors             []*PodSelector
+	stages                []*Stage
+	csiNodeGetter         CSINodeGetter
 	nodeSelectorLabels    labels.Set
 	interval              time.Duration
 	configMap             string
@@ -35,22 +46,52 @@ type NodeController struct {
 	nodeReadyHooks        []Hook
 	nodeStartUpObserver   NodeStartUpObserver
 	taintNodeNotReadyName string
+	notReadyAnnotations   map[string]string
+	// notReadyAnnotationTimeout is the time since node creation after
+	// which the not ready annotations are removed from nodes which are
+	// still not ready.
+	notReadyAnnotationTimeout time.Duration
+	recorder                  record.EventRecorder
+	dryRun                    bool
+	dryRunReports             dryRunReports
+	selectorsFirstReady       firstReadyTimes
+	// startUpPhasesObserved tracks the nodes whose startup phases were
+	// observed such that nodes tainted again later are not observed
+	// again.
+	startUpPhasesObserved *observedNodes
+	health                health
+	stats                 *readinessStats
 }
 
 // NewNodeController initializes a new NodeController.
-func NewNodeController(client kubernetes.Interface, selectors []*PodSelector, nodeSelectorLabels map[string]string, taintNodeNotReadyName string, interval time.Duration, configMap string, hooks []Hook, nodeStartUpObserver NodeStartUpObserver) (*NodeController, error) {
+func NewNodeController(client kubernetes.Interface, stages []*Stage, nodeSelectorLabels map[string]string, taintNodeNotReadyName string, interval time.Duration, configMap, namespace string, hooks []Hook, nodeStartUpObserver NodeStartUpObserver, notReadyAnnotations map[string]string, notReadyAnnotationTimeout time.Duration, dryRun bool) (*NodeController, error) {
 	controller := &NodeController{
-		Interface:             client,
-		selectors:             selectors,
-		nodeSelectorLabels:    labels.Set(nodeSelectorLabels),
-		interval:              interval,
-		configMap:             configMap,
-		nodeReadyHooks:        hooks,
-		nodeStartUpObserver:   nodeStartUpObserver,
-		taintNodeNotReadyName: taintNodeNotReadyName,
+		Interface:                 client,
+		stages:                    stages,
+		csiNodeGetter:             NewCSINodeGetter(client.StorageV1().RESTClient()),
+		nodeSelectorLabels:        labels.Set(nodeSelectorLabels),
+		interval:                  interval,
+		configMap:                 configMap,
+		namespace:                 namespace,
+		nodeReadyHooks:            hooks,
+		nodeStartUpObserver:       nodeStartUpObserver,
+		taintNodeNotReadyName:     taintNodeNotReadyName,
+		notReadyAnnotations:       notReadyAnnotations,
+		notReadyAnnotationTimeout: notReadyAnnotationTimeout,
+		dryRun:                    dryRun,
+		startUpPhasesObserved:     newObservedNodes(client, startUpPhasesObserver),
 	}
 
-	if controller.configMap != "" {
+	broadcaster := record.NewBroadcaster()
+	if dryRun {
+		// only log events in dry-run mode.
+		broadcaster.StartLogging(log.Infof)
+	} else {
+		broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events(v1.NamespaceAll)})
+	}
+	controller.recorder = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: controllerName})
+
+	if controller.configMap != "" && controller.namespace == "" {
 		// get Current Namespace
 		data, err := ioutil.ReadFile(serviceAccountNamespace)
 		if err != nil {
@@ -66,12 +107,17 @@ func NewNodeController(client kubernetes.Interface, selectors []*PodSelector, no
 }
 
 func (n *NodeController) runOnce() error {
+	start := time.Now()
+
 	// update selectors based on config map.
 	if n.configMap != "" {
 		err := n.getConfig()
 		if err != nil {
+			configReloads.WithLabelValues("failure").Inc()
 			return err
 		}
+		configReloads.WithLabelValues("success").Inc()
+		configLastReload.Set(float64(time.Now().Unix()))
 	}
 
 	opts := metav1.ListOptions{
@@ -79,31 +125,61 @@ func (n *NodeController) runOnce() error {
 	}
 
 	nodes, err := n.CoreV1().Nodes().List(opts)
+	recordAPIError("list", err)
 	if err != nil {
 		return err
 	}
 
 	log.Infof("Checking %d nodes for readiness", len(nodes.Items))
 
-	for _, node := range nodes.Items {
-		err = n.handleNode(&node)
+	if gc, ok := n.nodeStartUpObserver.(nodeGarbageCollector); ok {
+		gc.GarbageCollect(nodes.Items)
+	}
+
+	for _, hook := range n.nodeReadyHooks {
+		if gc, ok := hook.(nodeGarbageCollector); ok {
+			gc.GarbageCollect(nodes.Items)
+		}
+	}
+
+	n.dryRunReports.GarbageCollect(nodes.Items)
+	n.selectorsFirstReady.GarbageCollect(nodes.Items)
+	if n.startUpPhasesObserved != nil {
+		n.startUpPhasesObserved.GarbageCollect(nodes.Items)
+	}
+
+	// checks such as probes are bounded by their own timeouts.
+	readiness := n.nodesReady(context.Background(), nodes.Items)
+
+	n.stats = newReadinessStats()
+	for i, node := range nodes.Items {
+		if readiness[i].err != nil {
+			log.Error(readiness[i].err)
+			continue
+		}
+
+		err = n.reconcileNode(&node, readiness[i].stages)
 		if err != nil {
 			log.Error(err)
 			continue
 		}
 	}
+	n.stats.publish()
+	lastPassSuccess.Set(float64(time.Now().Unix()))
 
+	reconcileDuration.Observe(time.Since(start).Seconds())
 	return nil
 }
 
 // Run runs the controller loop until it receives a stop signal over the stop
 // channel.
 func (n *NodeController) Run(stopChan <-chan struct{}) {
+	n.health.Lock()
+	n.health.started = time.Now()
+	n.health.Unlock()
+
 	for {
-		err := n.runOnce()
-		if err != nil {
-			log.Error(err)
-		}
+		n.runPass()
 
 		select {
 		case <-time.After(n.interval):
@@ -114,96 +190,312 @@ func (n *NodeController) Run(stopChan <-chan struct{}) {
 	}
 }
 
-// handleNode checks if a node is ready and updates the notReady taint
-// accordingly.
-func (n *NodeController) handleNode(node *v1.Node) error {
-	ready, err := n.nodeReady(node)
+// runPass runs a single pass of the controller loop and records its outcome
+// for the health endpoints.
+func (n *NodeController) runPass() {
+	err := n.runOnce()
+	if err != nil {
+		log.Error(err)
+	}
+
+	n.health.Lock()
+	defer n.health.Unlock()
+	n.health.lastAttempt = time.Now()
+	if err == nil {
+		n.health.lastSuccess = n.health.lastAttempt
+	}
+}
+
+// nodeReadiness is the result of evaluating the readiness stages of a node.
+type nodeReadiness struct {
+	stages []*StageReadiness
+	err    error
+}
+
+// nodesReady evaluates the readiness stages of the nodes concurrently with up
+// to maxConcurrentNodes nodes at a time.
+func (n *NodeController) nodesReady(ctx context.Context, nodes []v1.Node) []nodeReadiness {
+	readiness := make([]nodeReadiness, len(nodes))
+	sem := make(chan struct{}, maxConcurrentNodes)
+	var wg sync.WaitGroup
+	for i := range nodes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			readiness[i].stages, readiness[i].err = n.nodeReady(ctx, &nodes[i])
+		}(i)
+	}
+	wg.Wait()
+	return readiness
+}
+
+// handleNode checks the readiness stages of a node and reconciles the taints
+// of all stages in a single update.
+func (n *NodeController) handleNode(ctx context.Context, node *v1.Node) error {
+	stages, err := n.nodeReady(ctx, node)
 	if err != nil {
 		return err
 	}
+	return n.reconcileNode(node, stages)
+}
+
+// reconcileNode reconciles the taints of all stages of a node in a single
+// update based on the evaluated readiness stages.
+func (n *NodeController) reconcileNode(node *v1.Node, stages []*StageReadiness) error {
+	taints := make(map[string]bool, len(stages))
+	for _, stage := range stages {
+		ready := stage.Ready()
+		if !ready && stage.Readiness != nil {
+			log.WithFields(log.Fields{
+				"node":      node.Name,
+				"stage":     stage.Stage.Name,
+				"not_ready": stage.Readiness.String(),
+			}).Info("Node not ready.")
+		}
+
+		if n.dryRun && stage.Readiness != nil {
+			for _, result := range stage.Readiness.Results {
+				decision := ""
+				if result.Blocking {
+					decision = "blocking"
+				}
+
+				key := fmt.Sprintf("blocking:%s/%s", stage.Stage.Name, result.Check)
+				if n.dryRunReports.report(node.Name, key, decision) {
+					dryRunBlockingChecks.WithLabelValues(result.Check).Inc()
+				}
+			}
+		}
+		taints[stage.Stage.Taint] = ready
+	}
 
-	err = n.setNodeReady(node, ready)
+	if n.stats != nil {
+		n.stats.add(stages)
+	}
+
+	// only track the ready times of nodes which are not marked ready yet.
+	ready := selectorsReady(stages)
+	for taint := range taints {
+		if hasTaint(node, taint) {
+			ready = n.selectorsFirstReady.update(node.Name, ready)
+			break
+		}
+	}
+
+	err := n.setNodeReady(node, taints, ready)
 	if err != nil {
 		return err
 	}
 
+	if !n.dryRun && !allReady(taints) {
+		for _, hook := range n.nodeReadyHooks {
+			if notReadyHook, ok := hook.(NotReadyHook); ok {
+				err := notReadyHook.NotReady(node)
+				if err != nil {
+					log.Errorf("Failed to call hook '%s' for not ready node %s: %v", hook.Name(), node.Name, err)
+				}
+			}
+		}
+	}
+
+	for _, stage := range stages {
+		if stage.Readiness != nil {
+			n.reportOptionalFailures(node, stage.Readiness, stage.Ready() && hasTaint(node, stage.Stage.Taint))
+		}
+	}
+
 	return nil
 }
 
-// nodeReady checks if the required pods are scheduled on the node and has
-// status ready.
-func (n *NodeController) nodeReady(node *v1.Node) (bool, error) {
+// selectorsReady returns the time each required selector became ready, keyed
+// by the selector name.
+func selectorsReady(stages []*StageReadiness) map[string]time.Time {
+	ready := make(map[string]time.Time)
+	for _, stage := range stages {
+		if stage.Readiness == nil {
+			continue
+		}
+
+		for _, result := range stage.Readiness.Results {
+			if !result.Optional && !result.ReadySince.IsZero() {
+				ready[result.Check] = result.ReadySince
+			}
+		}
+	}
+	return ready
+}
+
+// firstReadyTimes tracks the time each required selector of a node was first
+// seen ready. The ready time of a selector is derived from the last
+// transition of the Ready condition of its pods, which moves if a pod flaps.
+// Remembering the earliest time seen across passes keeps flaps after the
+// first pass seeing the selector ready from skewing the observed time.
+type firstReadyTimes struct {
+	sync.Mutex
+	times map[string]map[string]time.Time
+}
+
+// update merges the ready times of the selectors of a node with the times
+// seen in previous passes and returns the earliest time of each selector.
+func (f *firstReadyTimes) update(node string, ready map[string]time.Time) map[string]time.Time {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.times == nil {
+		f.times = make(map[string]map[string]time.Time)
+	}
+
+	times := f.times[node]
+	if times == nil {
+		times = make(map[string]time.Time, len(ready))
+		f.times[node] = times
+	}
+
+	for selector, readySince := range ready {
+		if first, ok := times[selector]; !ok || readySince.Before(first) {
+			times[selector] = readySince
+		}
+	}
+
+	merged := make(map[string]time.Time, len(times))
+	for selector, first := range times {
+		merged[selector] = first
+	}
+	return merged
+}
+
+// forget forgets the ready times of a node, e.g. once it was marked ready.
+func (f *firstReadyTimes) forget(node string) {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.times, node)
+}
+
+// GarbageCollect forgets the ready times of nodes which no longer exist.
+func (f *firstReadyTimes) GarbageCollect(nodes []v1.Node) {
+	f.Lock()
+	defer f.Unlock()
+
+	current := make(map[string]struct{}, len(nodes))
+	for _, node := range nodes {
+		current[node.Name] = struct{}{}
+	}
+
+	for name := range f.times {
+		if _, ok := current[name]; !ok {
+			delete(f.times, name)
+		}
+	}
+}
+
+// reportOptionalFailures records an event and increments the failure metric
+// for every optional check not ready when the node was marked ready. In
+// dry-run mode the node is never untainted, so a failure is only reported once
+// until the result changes.
+func (n *NodeController) reportOptionalFailures(node *v1.Node, readiness *NodeReadiness, markedReady bool) {
+	for _, result := range readiness.Results {
+		if !result.Optional {
+			continue
+		}
+
+		failed := markedReady && !result.Ready
+		if n.dryRun {
+			decision := ""
+			if failed {
+				decision = "failed"
+			}
+
+			if !n.dryRunReports.report(node.Name, "optional:"+result.Check, decision) {
+				continue
+			}
+		}
+
+		if !failed {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"node":     node.Name,
+			"selector": result.Check,
+		}).Warnf("Node marked ready with optional selector not ready: %s", result.Reason)
+
+		optionalSelectorFailures.WithLabelValues(result.Check).Inc()
+
+		if n.recorder != nil {
+			n.recorder.Eventf(node, v1.EventTypeWarning, "OptionalSelectorNotReady",
+				"Node marked ready with optional selector not ready: %s", result)
+		}
+	}
+}
+
+// nodeReady evaluates the readiness stages for the node in order. Stages
+// following a stage which is not ready are not evaluated unless they are
+// independent.
+func (n *NodeController) nodeReady(ctx context.Context, node *v1.Node) ([]*StageReadiness, error) {
 	opts := metav1.ListOptions{
 		FieldSelector: fmt.Sprintf("spec.nodeName=%s", node.ObjectMeta.Name),
 	}
 
 	pods, err := n.CoreV1().Pods(v1.NamespaceAll).List(opts)
+	recordAPIError("list", err)
 	if err != nil {
-		return false, err
-	}
-
-	readyResources := make([]*PodSelector, 0, len(n.selectors))
-	for _, identifier := range n.selectors {
-		for _, pod := range pods.Items {
-			if pod.ObjectMeta.Namespace == identifier.Namespace &&
-				containLabels(pod.ObjectMeta.Labels, identifier.Labels) {
-				if podReady(&pod) {
-					readyResources = append(readyResources, identifier)
-				} else {
-					// TODO: find all not ready pods
-					log.WithFields(log.Fields{
-						"pod":       pod.Name,
-						"namespace": pod.Namespace,
-						"node":      node.Name,
-					}).Warn("Pod not ready.")
-				}
-				break
-			}
-		}
+		return nil, err
 	}
 
-	if len(readyResources) != len(n.selectors) {
-		return false, nil
+	snapshot := NewNodeSnapshot(time.Now().UTC(), pods.Items, n.csiNodeGetter)
+
+	stages := make([]*StageReadiness, 0, len(n.stages))
+	ready := true
+	for _, stage := range n.stages {
+		stageReadiness := &StageReadiness{Stage: stage}
+		if ready || stage.Independent {
+			readiness, err := evaluateChecks(ctx, stage.Checks, node, snapshot)
+			if err != nil {
+				return nil, fmt.Errorf("stage %s: %v", stage.Name, err)
+			}
+			stageReadiness.Readiness = readiness
+			if !stage.Independent {
+				ready = ready && readiness.Ready()
+			}
+		}
+		stages = append(stages, stageReadiness)
 	}
 
-	return true, nil
+	return stages, nil
 }
 
-// setNodeReady sets node taint macthing ready value. E.g. sets NotReady taint
-// if ready is false, and removes the taint (if exists) when ready is true.
-func (n *NodeController) setNodeReady(node *v1.Node, ready bool) error {
+// setNodeReady reconciles the taints of the node in a single update. Taints
+// mapped to ready are removed (if they exist) and taints mapped to not ready
+// are added (if they don't exist). The node is considered ready once all the
+// taints are removed, at which point its startup is observed with the time
+// each required selector became ready. The not ready annotations are added
+// to or removed from the node in the same update.
+func (n *NodeController) setNodeReady(node *v1.Node, taints map[string]bool, selectorsReady map[string]time.Time) error {
 	setNodeReadiness := func() error {
 		updatedNode, err := n.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+		recordAPIError("get", err)
 		if err != nil {
 			return backoff.Permanent(err)
 		}
 
-		// if ready, remove notReady taint if exists on the node
-		if ready {
-			var newTaints []v1.Taint
-			for _, taint := range updatedNode.Spec.Taints {
-				if taint.Key != n.taintNodeNotReadyName {
-					newTaints = append(newTaints, taint)
-				}
-			}
-
-			if len(newTaints) == len(updatedNode.Spec.Taints) {
-				return nil
-			}
-			updatedNode.Spec.Taints = newTaints
-		} else {
-			if hasTaint(updatedNode, n.taintNodeNotReadyName) {
-				return nil
-			}
+		newTaints, added, removed := reconcileTaints(updatedNode.Spec.Taints, taints)
+		addedAnnotations, removedAnnotations := reconcileAnnotations(updatedNode, n.notReadyAnnotations, allReady(taints) || n.notReadyAnnotationsExpired(updatedNode, time.Now()))
+		if n.dryRun {
+			n.reportDryRun(updatedNode, taints, added, removed)
+			logAnnotationChanges(updatedNode, addedAnnotations, removedAnnotations, true)
+			return nil
+		}
 
-			taint := v1.Taint{
-				Key:    n.taintNodeNotReadyName,
-				Effect: v1.TaintEffectNoSchedule,
-			}
-			updatedNode.Spec.Taints = append(updatedNode.Spec.Taints, taint)
+		if len(added) == 0 && len(removed) == 0 && len(addedAnnotations) == 0 && len(removedAnnotations) == 0 {
+			return nil
 		}
 
+		updatedNode.Spec.Taints = newTaints
+
 		_, err = n.CoreV1().Nodes().Update(updatedNode)
+		recordAPIError("update", err)
 		if err != nil {
 			// automatically retry if there was a conflicting update.
 			if errors.IsConflict(err) {
@@ -212,31 +504,50 @@ func (n *NodeController) setNodeReady(node *v1.Node, ready bool) error {
 			return backoff.Permanent(err)
 		}
 
-		if ready {
+		for _, taint := range removed {
 			log.WithFields(log.Fields{
 				"action": "removed",
-				"taint":  n.taintNodeNotReadyName,
+				"taint":  taint,
 				"node":   updatedNode.ObjectMeta.Name,
 			}).Info("")
+			taintChanges.WithLabelValues("removed", taint).Inc()
+		}
 
-			if n.nodeStartUpObserver != nil {
-				// observe node startup duration
-				n.nodeStartUpObserver.ObserveNode(*updatedNode)
-			}
-
-			// trigger hooks on node ready.
-			for _, hook := range n.nodeReadyHooks {
-				err := hook.Trigger(updatedNode.Spec.ProviderID)
-				if err != nil {
-					log.Errorf("Failed to trigger hook '%s': %v", hook.Name(), err)
-				}
-			}
-		} else {
+		for _, taint := range added {
 			log.WithFields(log.Fields{
 				"action": "added",
-				"taint":  n.taintNodeNotReadyName,
+				"taint":  taint,
 				"node":   updatedNode.ObjectMeta.Name,
 			}).Info("")
+			taintChanges.WithLabelValues("added", taint).Inc()
+		}
+
+		logAnnotationChanges(updatedNode, addedAnnotations, removedAnnotations, false)
+
+		if len(removed) == 0 || !allReady(taints) {
+			return nil
+		}
+
+		startUp := NodeStartUp{
+			Node:           *updatedNode,
+			SelectorsReady: selectorsReady,
+			Ready:          time.Now().UTC(),
+		}
+		// only the first transition to ready is part of the startup.
+		if n.startUpPhasesObserved != nil && !n.startUpPhasesObserved.observed(updatedNode) {
+			observeStartUpPhases(startUp)
+			n.startUpPhasesObserved.markObserved(updatedNode, startUp.Ready)
+		}
+		n.selectorsFirstReady.forget(updatedNode.Name)
+
+		if n.nodeStartUpObserver != nil {
+			// observe node startup duration
+			n.nodeStartUpObserver.ObserveNode(startUp)
+		}
+
+		// trigger hooks on node ready.
+		for _, hook := range n.nodeReadyHooks {
+			triggerHook(hook, updatedNode)
 		}
 
 		return nil
@@ -246,9 +557,140 @@ func (n *NodeController) setNodeReady(node *v1.Node, ready bool) error {
 	return backoff.Retry(setNodeReadiness, backoffCfg)
 }
 
-// getConfig gets a selector config from a config map.
+// triggerHook triggers the hook for the node and records its invocation,
+// latency and failure.
+func triggerHook(hook Hook, node *v1.Node) {
+	start := time.Now()
+	var err error
+	if nodeHook, ok := hook.(NodeHook); ok {
+		err = nodeHook.TriggerNode(node)
+	} else {
+		err = hook.Trigger(node.Spec.ProviderID)
+	}
+	hookDuration.WithLabelValues(hook.Name()).Observe(time.Since(start).Seconds())
+	hookInvocations.WithLabelValues(hook.Name()).Inc()
+	if err != nil {
+		hookFailures.WithLabelValues(hook.Name()).Inc()
+		log.Errorf("Failed to trigger hook '%s': %v", hook.Name(), err)
+	}
+}
+
+// reportDryRun logs and records the taint changes which would have been made
+// to the node if not running in dry-run mode. As the changes are never made,
+// each decision is only reported once until it changes.
+func (n *NodeController) reportDryRun(node *v1.Node, taints map[string]bool, added, removed []string) {
+	decisions := make(map[string]string, len(taints))
+	for _, taint := range removed {
+		decisions[taint] = "untaint"
+	}
+
+	for _, taint := range added {
+		decisions[taint] = "taint"
+	}
+
+	reported := make(map[string]bool, len(taints))
+	for taint := range taints {
+		reported[taint] = n.dryRunReports.report(node.Name, "taint:"+taint, decisions[taint])
+	}
+
+	wouldUntaint := false
+	for _, taint := range removed {
+		if !reported[taint] {
+			continue
+		}
+		wouldUntaint = true
+
+		log.WithFields(log.Fields{
+			"action":  "would-remove",
+			"taint":   taint,
+			"node":    node.ObjectMeta.Name,
+			"dry_run": true,
+		}).Info("")
+
+		dryRunTaintDecisions.WithLabelValues("untaint", taint).Inc()
+
+		if n.recorder != nil {
+			n.recorder.Eventf(node, v1.EventTypeNormal, "DryRunUntaint", "Would remove taint %s", taint)
+		}
+	}
+
+	for _, taint := range added {
+		if !reported[taint] {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"action":  "would-add",
+			"taint":   taint,
+			"node":    node.ObjectMeta.Name,
+			"dry_run": true,
+		}).Info("")
+
+		dryRunTaintDecisions.WithLabelValues("taint", taint).Inc()
+
+		if n.recorder != nil {
+			n.recorder.Eventf(node, v1.EventTypeNormal, "DryRunTaint", "Would add taint %s", taint)
+		}
+	}
+
+	if wouldUntaint && allReady(taints) {
+		for _, hook := range n.nodeReadyHooks {
+			log.WithFields(log.Fields{
+				"node":    node.ObjectMeta.Name,
+				"dry_run": true,
+			}).Infof("Would trigger hook '%s'", hook.Name())
+		}
+	}
+}
+
+// reconcileTaints returns the node taints updated according to the readiness
+// of the managed taints along with the sorted keys of the added and removed
+// taints.
+func reconcileTaints(nodeTaints []v1.Taint, taints map[string]bool) ([]v1.Taint, []string, []string) {
+	var newTaints []v1.Taint
+	var removed []string
+	existing := make(map[string]struct{}, len(nodeTaints))
+	for _, taint := range nodeTaints {
+		existing[taint.Key] = struct{}{}
+		if ready, ok := taints[taint.Key]; ok && ready {
+			removed = append(removed, taint.Key)
+			continue
+		}
+		newTaints = append(newTaints, taint)
+	}
+
+	var added []string
+	for key, ready := range taints {
+		if _, ok := existing[key]; !ok && !ready {
+			added = append(added, key)
+		}
+	}
+	sort.Strings(added)
+
+	for _, key := range added {
+		newTaints = append(newTaints, v1.Taint{
+			Key:    key,
+			Effect: v1.TaintEffectNoSchedule,
+		})
+	}
+
+	return newTaints, added, removed
+}
+
+// allReady returns true if all taints are mapped to ready.
+func allReady(taints map[string]bool) bool {
+	for _, ready := range taints {
+		if !ready {
+			return false
+		}
+	}
+	return true
+}
+
+// getConfig gets the readiness checks config from a config map.
 func (n *NodeController) getConfig() error {
 	configMap, err := n.CoreV1().ConfigMaps(n.namespace).Get(n.configMap, metav1.GetOptions{})
+	recordAPIError("get", err)
 	if err != nil {
 		return err
 	}
@@ -258,12 +700,21 @@ func (n *NodeController) getConfig() error {
 		return fmt.Errorf("expected key '%s' not present in config map", ConfigMapSelectorsKey)
 	}
 
-	selectors, err := ReadSelectors(data)
+	config, err := ReadConfig(data)
 	if err != nil {
 		return err
 	}
 
-	n.selectors = selectors
+	stages, err := config.ReadinessStages(n.taintNodeNotReadyName)
+	if err != nil {
+		return err
+	}
+
+	n.stages = stages
+
+	n.health.Lock()
+	n.health.configLoaded = true
+	n.health.Unlock()
 	return nil
 }
 
@@ -287,8 +738,62 @@ func containLabels(labels, expectedLabels map[string]string) bool {
 	return true
 }
 
-// podReady returns true if all containers in the pod are ready.
-func podReady(pod *v1.Pod) bool {
+// podReady returns true if the pod is ready according to the readiness
+// requirements of the selector.
+func podReady(pod *v1.Pod, selector *PodSelector, now time.Time) bool {
+	if pod.ObjectMeta.DeletionTimestamp != nil && !selector.AllowTerminating {
+		return false
+	}
+
+	if selector.RequireRunning && pod.Status.Phase != v1.PodRunning {
+		return false
+	}
+
+	condition := podReadyCondition(pod)
+
+	switch selector.ReadinessMode {
+	case ReadinessModeContainers:
+		if !containersReady(pod) {
+			return false
+		}
+	default:
+		if condition == nil || condition.Status != v1.ConditionTrue {
+			return false
+		}
+	}
+
+	if selector.MinReadySeconds > 0 {
+		if condition == nil || condition.Status != v1.ConditionTrue {
+			return false
+		}
+
+		minReady := time.Duration(selector.MinReadySeconds) * time.Second
+		if condition.LastTransitionTime.Add(minReady).After(now) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// podReadyCondition returns the PodReady condition of the pod or nil if the
+// pod doesn't have the condition.
+func podReadyCondition(pod *v1.Pod) *v1.PodCondition {
+	for i, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return &pod.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// containersReady returns true if the pod has container statuses and all
+// containers in the pod are ready.
+func containersReady(pod *v1.Pod) bool {
+	if len(pod.Status.ContainerStatuses) == 0 {
+		return false
+	}
+
 	for _, containerStatus := range pod.Status.ContainerStatuses {
 		if !containerStatus.Ready {
 			return false
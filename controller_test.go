@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
@@ -37,6 +45,15 @@ func setupMockKubernetes(t *testing.T, node *v1.Node, config *v1.ConfigMap) kube
 			Name:      "foo",
 			Labels:    map[string]string{"foo": "bar"},
 		},
+		Status: v1.PodStatus{
+			Phase: v1.PodRunning,
+			Conditions: []v1.PodCondition{
+				{
+					Type:   v1.PodReady,
+					Status: v1.ConditionTrue,
+				},
+			},
+		},
 	}
 
 	_, err := client.CoreV1().Pods(pod.Namespace).Create(pod)
@@ -85,9 +102,10 @@ func TestRunOnce(t *testing.T) {
 	} {
 		t.Run(tc.msg, func(t *testing.T) {
 			controller := &NodeController{
-				Interface: setupMockKubernetes(t, tc.node, tc.config),
-				configMap: "",
-				namespace: namespace,
+				Interface:             setupMockKubernetes(t, tc.node, tc.config),
+				configMap:             "",
+				namespace:             namespace,
+				taintNodeNotReadyName: taintNodeNotReadyName,
 			}
 			if tc.config != nil {
 				controller.configMap = tc.config.Name
@@ -142,14 +160,14 @@ foo: bar`},
 
 func TestNodeReady(t *testing.T) {
 	for _, tc := range []struct {
-		msg       string
-		selectors []*PodSelector
-		ready     bool
+		msg    string
+		checks []ReadinessCheck
+		ready  bool
 	}{
 		{
 			msg: "node should be ready when pod is found",
-			selectors: []*PodSelector{
-				{
+			checks: []ReadinessCheck{
+				&PodSelector{
 					Namespace: "default",
 					Labels:    map[string]string{"foo": "bar"},
 				},
@@ -158,29 +176,99 @@ func TestNodeReady(t *testing.T) {
 		},
 		{
 			msg: "node should not be ready when pod is not found",
-			selectors: []*PodSelector{
-				{
+			checks: []ReadinessCheck{
+				&PodSelector{
 					Namespace: "default",
 					Labels:    map[string]string{"foo": "baz"},
 				},
 			},
 			ready: false,
 		},
+		{
+			msg: "node should not be ready when fewer than minReady pods are found",
+			checks: []ReadinessCheck{
+				&PodSelector{
+					Namespace: "default",
+					Labels:    map[string]string{"foo": "bar"},
+					MinReady:  2,
+				},
+			},
+			ready: false,
+		},
 	} {
 		t.Run(tc.msg, func(t *testing.T) {
 			controller := &NodeController{
 				Interface: setupMockKubernetes(t, nil, nil),
-				selectors: tc.selectors,
+				stages:    []*Stage{{Taint: taintNodeNotReadyName, Checks: tc.checks}},
+			}
+			stages, err := controller.nodeReady(context.Background(), &v1.Node{})
+			if err != nil {
+				t.Errorf("should not fail: %s", err)
 			}
-			ready, _ := controller.nodeReady(&v1.Node{})
 
-			if ready != tc.ready {
-				t.Errorf("expected ready %t, got %t", tc.ready, ready)
+			if stages[0].Ready() != tc.ready {
+				t.Errorf("expected ready %t, got %t", tc.ready, stages[0].Ready())
 			}
 		})
 	}
 }
 
+func TestHandleNodeOptionalSelector(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "foo",
+		},
+		Spec: v1.NodeSpec{
+			Taints: []v1.Taint{
+				{
+					Key: taintNodeNotReadyName,
+				},
+			},
+		},
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	controller := &NodeController{
+		Interface: setupMockKubernetes(t, node, nil),
+		stages: []*Stage{
+			{
+				Taint: taintNodeNotReadyName,
+				Checks: []ReadinessCheck{
+					&PodSelector{
+						Namespace: "default",
+						Labels:    map[string]string{"foo": "bar"},
+					},
+					&PodSelector{
+						Namespace: "default",
+						Labels:    map[string]string{"foo": "baz"},
+						Optional:  true,
+					},
+				},
+			},
+		},
+		taintNodeNotReadyName: taintNodeNotReadyName,
+		recorder:              recorder,
+	}
+
+	err := controller.handleNode(context.Background(), node)
+	if err != nil {
+		t.Errorf("should not fail: %s", err)
+	}
+
+	n, err := controller.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Errorf("should not fail: %s", err)
+	}
+
+	if hasTaint(n, taintNodeNotReadyName) {
+		t.Errorf("node should not have taint when only optional selectors are not ready")
+	}
+
+	if len(recorder.Events) != 1 {
+		t.Errorf("expected 1 event, got %d", len(recorder.Events))
+	}
+}
+
 func TestSetNodeReady(t *testing.T) {
 	for _, tc := range []struct {
 		msg   string
@@ -228,7 +316,7 @@ func TestSetNodeReady(t *testing.T) {
 				Interface:             setupMockKubernetes(t, tc.node, nil),
 				taintNodeNotReadyName: taintNodeNotReadyName,
 			}
-			_ = controller.setNodeReady(tc.node, tc.ready)
+			_ = controller.setNodeReady(tc.node, map[string]bool{taintNodeNotReadyName: tc.ready}, nil)
 
 			n, err := controller.CoreV1().Nodes().Get(tc.node.Name, metav1.GetOptions{})
 			if err != nil {
@@ -299,9 +387,10 @@ func TestGetConfig(t *testing.T) {
 	} {
 		t.Run(tc.msg, func(t *testing.T) {
 			controller := &NodeController{
-				Interface: setupMockKubernetes(t, nil, tc.config),
-				configMap: "config",
-				namespace: namespace,
+				Interface:             setupMockKubernetes(t, nil, tc.config),
+				configMap:             "config",
+				namespace:             namespace,
+				taintNodeNotReadyName: taintNodeNotReadyName,
 			}
 
 			err := controller.getConfig()
@@ -352,23 +441,373 @@ func TestContainLabels(t *testing.T) {
 }
 
 func TestPodReady(t *testing.T) {
-	pod := &v1.Pod{
-		Status: v1.PodStatus{
-			ContainerStatuses: []v1.ContainerStatus{
+	now := time.Now().UTC()
+	readyCondition := []v1.PodCondition{
+		{
+			Type:               v1.PodReady,
+			Status:             v1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(now.Add(-30 * time.Second)),
+		},
+	}
+	deletionTimestamp := metav1.NewTime(now)
+
+	for _, tc := range []struct {
+		msg      string
+		pod      *v1.Pod
+		selector *PodSelector
+		ready    bool
+	}{
+		{
+			msg: "pod with ready condition should be ready",
+			pod: &v1.Pod{
+				Status: v1.PodStatus{Conditions: readyCondition},
+			},
+			selector: &PodSelector{},
+			ready:    true,
+		},
+		{
+			msg: "pod without ready condition should not be ready",
+			pod: &v1.Pod{
+				Status: v1.PodStatus{
+					ContainerStatuses: []v1.ContainerStatus{{Ready: true}},
+				},
+			},
+			selector: &PodSelector{},
+			ready:    false,
+		},
+		{
+			msg: "pod with false ready condition should not be ready",
+			pod: &v1.Pod{
+				Status: v1.PodStatus{
+					Conditions: []v1.PodCondition{
+						{Type: v1.PodReady, Status: v1.ConditionFalse},
+					},
+				},
+			},
+			selector: &PodSelector{},
+			ready:    false,
+		},
+		{
+			msg: "terminating pod should not be ready",
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{DeletionTimestamp: &deletionTimestamp},
+				Status:     v1.PodStatus{Conditions: readyCondition},
+			},
+			selector: &PodSelector{},
+			ready:    false,
+		},
+		{
+			msg: "terminating pod should be ready when allowed",
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{DeletionTimestamp: &deletionTimestamp},
+				Status:     v1.PodStatus{Conditions: readyCondition},
+			},
+			selector: &PodSelector{AllowTerminating: true},
+			ready:    true,
+		},
+		{
+			msg: "pending pod should not be ready when running is required",
+			pod: &v1.Pod{
+				Status: v1.PodStatus{
+					Phase:      v1.PodPending,
+					Conditions: readyCondition,
+				},
+			},
+			selector: &PodSelector{RequireRunning: true},
+			ready:    false,
+		},
+		{
+			msg: "pod should not be ready before minReadySeconds",
+			pod: &v1.Pod{
+				Status: v1.PodStatus{Conditions: readyCondition},
+			},
+			selector: &PodSelector{MinReadySeconds: 60},
+			ready:    false,
+		},
+		{
+			msg: "pod should be ready after minReadySeconds",
+			pod: &v1.Pod{
+				Status: v1.PodStatus{Conditions: readyCondition},
+			},
+			selector: &PodSelector{MinReadySeconds: 10},
+			ready:    true,
+		},
+		{
+			msg: "pod with ready containers should be ready in containers mode",
+			pod: &v1.Pod{
+				Status: v1.PodStatus{
+					ContainerStatuses: []v1.ContainerStatus{{Ready: true}},
+				},
+			},
+			selector: &PodSelector{ReadinessMode: ReadinessModeContainers},
+			ready:    true,
+		},
+		{
+			msg: "pod without container statuses should not be ready in containers mode",
+			pod: &v1.Pod{
+				Status: v1.PodStatus{Conditions: readyCondition},
+			},
+			selector: &PodSelector{ReadinessMode: ReadinessModeContainers},
+			ready:    false,
+		},
+		{
+			msg: "pod with not ready container should not be ready in containers mode",
+			pod: &v1.Pod{
+				Status: v1.PodStatus{
+					ContainerStatuses: []v1.ContainerStatus{{Ready: true}, {Ready: false}},
+				},
+			},
+			selector: &PodSelector{ReadinessMode: ReadinessModeContainers},
+			ready:    false,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			if podReady(tc.pod, tc.selector, now) != tc.ready {
+				t.Errorf("expected ready %t, got %t", tc.ready, !tc.ready)
+			}
+		})
+	}
+}
+
+func TestSetNodeReadyDryRun(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "foo",
+		},
+		Spec: v1.NodeSpec{
+			Taints: []v1.Taint{
+				{
+					Key: taintNodeNotReadyName,
+				},
+			},
+		},
+	}
+
+	hook := &mockHook{}
+	recorder := record.NewFakeRecorder(10)
+	controller := &NodeController{
+		Interface:      setupMockKubernetes(t, node, nil),
+		nodeReadyHooks: []Hook{hook},
+		recorder:       recorder,
+		dryRun:         true,
+	}
+
+	err := controller.setNodeReady(node, map[string]bool{taintNodeNotReadyName: true, "bar": false}, nil)
+	if err != nil {
+		t.Errorf("should not fail: %s", err)
+	}
+
+	n, err := controller.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Errorf("should not fail: %s", err)
+	}
+
+	if !hasTaint(n, taintNodeNotReadyName) || hasTaint(n, "bar") {
+		t.Errorf("expected taints to not be changed in dry-run mode, got %v", n.Spec.Taints)
+	}
+
+	if hook.triggered != 0 {
+		t.Errorf("expected hooks to not be triggered in dry-run mode, got %d", hook.triggered)
+	}
+
+	if len(recorder.Events) != 2 {
+		t.Errorf("expected 2 events, got %d", len(recorder.Events))
+	}
+}
+
+func counterValue(t *testing.T, counter prometheus.Counter) float64 {
+	var metric dto.Metric
+	err := counter.Write(&metric)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+	return metric.GetCounter().GetValue()
+}
+
+func TestRunOnceDryRunReportsOnce(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "foo",
+		},
+		Spec: v1.NodeSpec{
+			Taints: []v1.Taint{
 				{
-					Ready: true,
+					Key: taintNodeNotReadyName,
 				},
 			},
 		},
 	}
 
-	if !podReady(pod) {
-		t.Error("expected pod to be ready")
+	recorder := record.NewFakeRecorder(10)
+	controller := &NodeController{
+		Interface: setupMockKubernetes(t, node, nil),
+		stages: []*Stage{
+			{
+				Name:  "dry-run",
+				Taint: taintNodeNotReadyName,
+				Checks: []ReadinessCheck{
+					&PodSelector{
+						Namespace: "default",
+						Labels:    map[string]string{"foo": "bar"},
+					},
+					&PodSelector{
+						Namespace: "default",
+						Labels:    map[string]string{"foo": "baz"},
+						Optional:  true,
+					},
+				},
+			},
+			{
+				Name:        "blocked",
+				Taint:       "blocked",
+				Independent: true,
+				Checks: []ReadinessCheck{
+					&PodSelector{
+						Namespace: "default",
+						Labels:    map[string]string{"foo": "qux"},
+					},
+				},
+			},
+		},
+		taintNodeNotReadyName: taintNodeNotReadyName,
+		recorder:              recorder,
+		dryRun:                true,
+	}
+
+	untaint := dryRunTaintDecisions.WithLabelValues("untaint", taintNodeNotReadyName)
+	taint := dryRunTaintDecisions.WithLabelValues("taint", "blocked")
+	blocking := dryRunBlockingChecks.WithLabelValues("default:foo=qux")
+	untaintBefore := counterValue(t, untaint)
+	taintBefore := counterValue(t, taint)
+	blockingBefore := counterValue(t, blocking)
+
+	for i := 0; i < 2; i++ {
+		err := controller.runOnce()
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+	}
+
+	// would remove, would add and optional failure.
+	if len(recorder.Events) != 3 {
+		t.Errorf("expected 3 events, got %d", len(recorder.Events))
 	}
 
-	pod.Status.ContainerStatuses[0].Ready = false
+	if v := counterValue(t, untaint) - untaintBefore; v != 1 {
+		t.Errorf("expected 1 untaint decision, got %.0f", v)
+	}
+
+	if v := counterValue(t, taint) - taintBefore; v != 1 {
+		t.Errorf("expected 1 taint decision, got %.0f", v)
+	}
+
+	if v := counterValue(t, blocking) - blockingBefore; v != 1 {
+		t.Errorf("expected 1 blocking check, got %.0f", v)
+	}
+
+	// the decision is reported again once it changes.
+	controller.stages[0].Checks = controller.stages[0].Checks[:1]
+	controller.stages[0].Checks = append(controller.stages[0].Checks, &PodSelector{
+		Namespace: "default",
+		Labels:    map[string]string{"foo": "qux"},
+	})
+
+	err := controller.runOnce()
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	err = controller.runOnce()
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	if len(recorder.Events) != 3 {
+		t.Errorf("expected no events while the node is not ready, got %d", len(recorder.Events)-3)
+	}
+
+	controller.stages[0].Checks = controller.stages[0].Checks[:1]
+	err = controller.runOnce()
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	if v := counterValue(t, untaint) - untaintBefore; v != 2 {
+		t.Errorf("expected 2 untaint decisions, got %.0f", v)
+	}
+}
+
+func gaugeValue(t *testing.T, gauge prometheus.Gauge) float64 {
+	var metric dto.Metric
+	err := gauge.Write(&metric)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+	return metric.GetGauge().GetValue()
+}
+
+func TestRunOnceLastPassSuccess(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "foo",
+		},
+	}
+
+	client := setupMockKubernetes(t, node, nil).(*fake.Clientset)
+	controller := &NodeController{
+		Interface: client,
+		stages: []*Stage{
+			{
+				Name:  "default",
+				Taint: taintNodeNotReadyName,
+				Checks: []ReadinessCheck{
+					&PodSelector{
+						Namespace: "default",
+						Labels:    map[string]string{"foo": "bar"},
+					},
+				},
+			},
+		},
+		taintNodeNotReadyName: taintNodeNotReadyName,
+		recorder:              record.NewFakeRecorder(10),
+	}
+
+	lastPassSuccess.Set(1)
+
+	// a failed node list should leave the timestamp of the last completed
+	// pass as is.
+	client.PrependReactor("list", "nodes", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("list failed")
+	})
+
+	err := controller.runOnce()
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	if v := gaugeValue(t, lastPassSuccess); v != 1 {
+		t.Errorf("expected last pass timestamp to be unchanged, got %f", v)
+	}
+
+	client.ReactionChain = client.ReactionChain[1:]
+
+	err = controller.runOnce()
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	if v := gaugeValue(t, lastPassSuccess); v <= 1 {
+		t.Errorf("expected last pass timestamp to be updated, got %f", v)
+	}
+}
+
+func TestNewNodeControllerNamespace(t *testing.T) {
+	controller, err := NewNodeController(setupMockKubernetes(t, nil, nil), nil, nil, taintNodeNotReadyName, time.Second, "config", namespace, nil, nil, nil, 0, false)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
 
-	if podReady(pod) {
-		t.Error("expected pod to not be ready")
+	if controller.namespace != namespace {
+		t.Errorf("expected namespace %s, got %s", namespace, controller.namespace)
 	}
 }
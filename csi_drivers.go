@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	csiNodesPath = "/apis/storage.k8s.io/v1/csinodes"
+)
+
+// CSIDriversCheck requires CSI drivers to be registered for the node.
+type CSIDriversCheck struct {
+	Drivers CSIDriverRequirements `yaml:"drivers"`
+}
+
+// Name returns the name of the check.
+func (c *CSIDriversCheck) Name() string {
+	return "csiDrivers"
+}
+
+// Evaluate checks if the required CSI drivers are registered in the node's
+// CSINode object.
+func (c *CSIDriversCheck) Evaluate(ctx context.Context, node *v1.Node, snapshot *NodeSnapshot) (*CheckResult, error) {
+	drivers, err := snapshot.CSINodeDrivers(node.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCheckResult(c, c.Drivers.unmet(drivers)), nil
+}
+
+// validate validates the CSI driver requirements.
+func (c *CSIDriversCheck) validate() error {
+	return c.Drivers.validate()
+}
+
+// CSIDriverRequirement requires a CSI driver to be registered for the node
+// in the node's CSINode object.
+type CSIDriverRequirement struct {
+	Name string `yaml:"name"`
+	// TopologyKeys are topology keys the driver must have registered.
+	TopologyKeys []string `yaml:"topologyKeys"`
+}
+
+// CSIDriverRequirements is a list of CSIDriverRequirement definitions.
+type CSIDriverRequirements []CSIDriverRequirement
+
+func (c CSIDriverRequirements) String() string {
+	strs := make([]string, 0, len(c))
+	for _, driver := range c {
+		str := driver.Name
+		if len(driver.TopologyKeys) > 0 {
+			str = fmt.Sprintf("%s:%s", str, strings.Join(driver.TopologyKeys, ","))
+		}
+		strs = append(strs, str)
+	}
+	return strings.Join(strs, " - ")
+}
+
+// Set parses a CSI driver requirement in the format
+// <name>[:<topologyKey>,+] and adds it to the list.
+func (c *CSIDriverRequirements) Set(value string) error {
+	divide := strings.Split(value, ":")
+	if len(divide) > 2 || divide[0] == "" {
+		return fmt.Errorf("invalid CSI driver format")
+	}
+
+	driver := CSIDriverRequirement{Name: divide[0]}
+	if len(divide) == 2 {
+		driver.TopologyKeys = strings.Split(divide[1], ",")
+	}
+
+	*c = append(*c, driver)
+	return nil
+}
+
+// IsCumulative always return true because it's allowed to call Set multiple
+// times.
+func (c CSIDriverRequirements) IsCumulative() bool {
+	return true
+}
+
+// validate validates the CSI driver requirements.
+func (c CSIDriverRequirements) validate() error {
+	for _, driver := range c {
+		if driver.Name == "" {
+			return fmt.Errorf("CSI driver name must be specified")
+		}
+	}
+	return nil
+}
+
+// unmet returns a description of every CSI driver requirement not met by the
+// registered drivers.
+func (c CSIDriverRequirements) unmet(registered []CSINodeDriver) []string {
+	drivers := make(map[string]CSINodeDriver, len(registered))
+	for _, driver := range registered {
+		drivers[driver.Name] = driver
+	}
+
+	var unmet []string
+	for _, expected := range c {
+		driver, ok := drivers[expected.Name]
+		if !ok {
+			unmet = append(unmet, fmt.Sprintf("CSI driver %s not registered", expected.Name))
+			continue
+		}
+
+		for _, key := range expected.TopologyKeys {
+			if !containsString(driver.TopologyKeys, key) {
+				unmet = append(unmet, fmt.Sprintf("CSI driver %s missing topology key %s", expected.Name, key))
+			}
+		}
+	}
+	return unmet
+}
+
+// CSINodeDriver is a CSI driver registered in a CSINode object.
+type CSINodeDriver struct {
+	Name         string   `json:"name"`
+	NodeID       string   `json:"nodeID"`
+	TopologyKeys []string `json:"topologyKeys"`
+}
+
+// CSINodeGetter describes a client which can get the CSI drivers registered
+// for a node.
+type CSINodeGetter interface {
+	CSINodeDrivers(nodeName string) ([]CSINodeDriver, error)
+}
+
+// restCSINodeGetter gets CSINode objects via the REST API. This avoids
+// depending on a client version with typed support for CSINode.
+type restCSINodeGetter struct {
+	client rest.Interface
+}
+
+// NewCSINodeGetter initializes a new CSINodeGetter using the REST client.
+func NewCSINodeGetter(client rest.Interface) CSINodeGetter {
+	return &restCSINodeGetter{client: client}
+}
+
+// CSINodeDrivers returns the drivers of the node's CSINode object. No drivers
+// are returned if the CSINode object doesn't exist yet.
+func (g *restCSINodeGetter) CSINodeDrivers(nodeName string) ([]CSINodeDriver, error) {
+	data, err := g.client.Get().AbsPath(csiNodesPath, nodeName).DoRaw()
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var csiNode struct {
+		Spec struct {
+			Drivers []CSINodeDriver `json:"drivers"`
+		} `json:"spec"`
+	}
+
+	err = json.Unmarshal(data, &csiNode)
+	if err != nil {
+		return nil, err
+	}
+
+	return csiNode.Spec.Drivers, nil
+}
+
+// containsString reports whether str is in strs.
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+type mockCSINodeGetter struct {
+	drivers []CSINodeDriver
+	err     error
+}
+
+func (g *mockCSINodeGetter) CSINodeDrivers(nodeName string) ([]CSINodeDriver, error) {
+	return g.drivers, g.err
+}
+
+func TestCSIDriverRequirementsUnmet(t *testing.T) {
+	registered := []CSINodeDriver{
+		{
+			Name:         "ebs.csi.aws.com",
+			TopologyKeys: []string{"topology.ebs.csi.aws.com/zone"},
+		},
+	}
+
+	for _, tc := range []struct {
+		msg          string
+		requirements CSIDriverRequirements
+		unmet        []string
+	}{
+		{
+			msg: "registered driver should be met",
+			requirements: CSIDriverRequirements{
+				{
+					Name:         "ebs.csi.aws.com",
+					TopologyKeys: []string{"topology.ebs.csi.aws.com/zone"},
+				},
+			},
+			unmet: nil,
+		},
+		{
+			msg: "missing driver and topology key should be reported",
+			requirements: CSIDriverRequirements{
+				{
+					Name:         "ebs.csi.aws.com",
+					TopologyKeys: []string{"topology.kubernetes.io/zone"},
+				},
+				{
+					Name: "efs.csi.aws.com",
+				},
+			},
+			unmet: []string{
+				"CSI driver ebs.csi.aws.com missing topology key topology.kubernetes.io/zone",
+				"CSI driver efs.csi.aws.com not registered",
+			},
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			unmet := tc.requirements.unmet(registered)
+			if !reflect.DeepEqual(unmet, tc.unmet) {
+				t.Errorf("expected %v, got %v", tc.unmet, unmet)
+			}
+		})
+	}
+}
+
+func TestSetCSIDriverRequirements(t *testing.T) {
+	var drivers CSIDriverRequirements
+	err := drivers.Set("ebs.csi.aws.com:topology.ebs.csi.aws.com/zone")
+	if err != nil {
+		t.Errorf("should not fail: %s", err)
+	}
+
+	expected := CSIDriverRequirements{
+		{
+			Name:         "ebs.csi.aws.com",
+			TopologyKeys: []string{"topology.ebs.csi.aws.com/zone"},
+		},
+	}
+
+	if !reflect.DeepEqual(drivers, expected) {
+		t.Errorf("expected %v, got %v", expected, drivers)
+	}
+
+	err = drivers.Set("")
+	if err == nil {
+		t.Error("expected failure")
+	}
+}
+
+func TestRESTCSINodeGetter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case csiNodesPath + "/foo":
+			fmt.Fprint(w, `{"spec":{"drivers":[{"name":"ebs.csi.aws.com","nodeID":"i-1234"}]}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+		}
+	}))
+	defer server.Close()
+
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	getter := NewCSINodeGetter(client.StorageV1().RESTClient())
+
+	drivers, err := getter.CSINodeDrivers("foo")
+	if err != nil {
+		t.Errorf("should not fail: %s", err)
+	}
+
+	if len(drivers) != 1 || drivers[0].Name != "ebs.csi.aws.com" {
+		t.Errorf("expected driver ebs.csi.aws.com, got %v", drivers)
+	}
+
+	drivers, err = getter.CSINodeDrivers("bar")
+	if err != nil {
+		t.Errorf("should not fail when CSINode doesn't exist: %s", err)
+	}
+
+	if len(drivers) != 0 {
+		t.Errorf("expected no drivers, got %v", drivers)
+	}
+}
+
+func TestNodeReadyCSIDrivers(t *testing.T) {
+	controller := &NodeController{
+		Interface: setupMockKubernetes(t, nil, nil),
+		stages: []*Stage{
+			{
+				Taint: taintNodeNotReadyName,
+				Checks: []ReadinessCheck{
+					&CSIDriversCheck{
+						Drivers: CSIDriverRequirements{
+							{Name: "ebs.csi.aws.com"},
+						},
+					},
+				},
+			},
+		},
+		csiNodeGetter: &mockCSINodeGetter{},
+	}
+
+	stages, err := controller.nodeReady(context.Background(), &v1.Node{})
+	if err != nil {
+		t.Errorf("should not fail: %s", err)
+	}
+
+	if stages[0].Ready() {
+		t.Error("expected node to not be ready without CSI driver")
+	}
+
+	controller.csiNodeGetter = &mockCSINodeGetter{
+		drivers: []CSINodeDriver{{Name: "ebs.csi.aws.com"}},
+	}
+
+	stages, err = controller.nodeReady(context.Background(), &v1.Node{})
+	if err != nil {
+		t.Errorf("should not fail: %s", err)
+	}
+
+	if !stages[0].Ready() {
+		t.Errorf("expected node to be ready, got: %s", stages[0].Readiness)
+	}
+}
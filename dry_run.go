@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+
+	"k8s.io/api/core/v1"
+)
+
+// dryRunReports remembers the decisions reported for each node in dry-run
+// mode. Nothing is changed in dry-run mode, so the same decisions are made in
+// every pass and would otherwise be reported over and over again.
+type dryRunReports struct {
+	sync.Mutex
+	decisions map[string]map[string]string
+}
+
+// report records the decision for the key of a node and returns true if it
+// should be reported, i.e. if it differs from the previous decision. An empty
+// decision clears the entry such that the next decision is reported again.
+func (r *dryRunReports) report(node, key, decision string) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	if decision == "" {
+		delete(r.decisions[node], key)
+		return false
+	}
+
+	if r.decisions[node][key] == decision {
+		return false
+	}
+
+	if r.decisions == nil {
+		r.decisions = make(map[string]map[string]string)
+	}
+
+	if r.decisions[node] == nil {
+		r.decisions[node] = make(map[string]string)
+	}
+	r.decisions[node][key] = decision
+	return true
+}
+
+// GarbageCollect forgets the decisions of nodes which no longer exist.
+func (r *dryRunReports) GarbageCollect(nodes []v1.Node) {
+	r.Lock()
+	defer r.Unlock()
+
+	current := make(map[string]struct{}, len(nodes))
+	for _, node := range nodes {
+		current[node.Name] = struct{}{}
+	}
+
+	for name := range r.decisions {
+		if _, ok := current[name]; !ok {
+			delete(r.decisions, name)
+		}
+	}
+}
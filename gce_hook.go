@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/mikkeloscar/kube-node-ready-controller/pkg/gce"
+	"github.com/mikkeloscar/kube-node-ready-controller/pkg/providerid"
+)
+
+const (
+	gceInstanceLabelHookName = "gce-instance-label"
+	// setLabelsMaxElapsedTime is the maximum time setting the instance
+	// labels is retried on conflicting label updates and failed requests.
+	setLabelsMaxElapsedTime = 30 * time.Second
+)
+
+// GCEInstanceLabelHook defines a hook setting a label on the GCE instance of
+// a node on node Ready. This allows the managed instance group or other
+// automation to tell when the instance is ready for workloads.
+type GCEInstanceLabelHook struct {
+	key        string
+	value      string
+	compute    gce.ComputeAPI
+	newBackOff func() backoff.BackOff
+}
+
+// NewGCEInstanceLabelHook creates a new GCE instance label hook setting the
+// label key to value.
+func NewGCEInstanceLabelHook(compute gce.ComputeAPI, key, value string) *GCEInstanceLabelHook {
+	return &GCEInstanceLabelHook{
+		key:     key,
+		value:   value,
+		compute: compute,
+		newBackOff: func() backoff.BackOff {
+			b := backoff.NewExponentialBackOff()
+			b.MaxElapsedTime = setLabelsMaxElapsedTime
+			return b
+		},
+	}
+}
+
+// Name returns the hook name.
+func (h *GCEInstanceLabelHook) Name() string {
+	return gceInstanceLabelHookName
+}
+
+// Trigger sets the label on the GCE instance. The update is retried if the
+// labels of the instance were changed concurrently or if a request was rate
+// limited or failed.
+func (h *GCEInstanceLabelHook) Trigger(providerID string) error {
+	instance, err := gceInstance(providerID)
+	if err != nil {
+		return err
+	}
+
+	setLabel := func() error {
+		computeInstance, err := h.compute.GetInstance(instance.Project, instance.Zone, instance.ID)
+		if apiErr, ok := err.(*gce.Error); ok && !retryableStatus(apiErr.StatusCode) {
+			return backoff.Permanent(err)
+		}
+		if err != nil {
+			return err
+		}
+
+		if computeInstance.Labels[h.key] == h.value {
+			return nil
+		}
+
+		labels := make(map[string]string, len(computeInstance.Labels)+1)
+		for k, v := range computeInstance.Labels {
+			labels[k] = v
+		}
+		labels[h.key] = h.value
+
+		err = h.compute.SetInstanceLabels(instance.Project, instance.Zone, instance.ID, labels, computeInstance.LabelFingerprint)
+		if apiErr, ok := err.(*gce.Error); ok && apiErr.StatusCode != http.StatusPreconditionFailed && !retryableStatus(apiErr.StatusCode) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+
+	return backoff.Retry(setLabel, h.newBackOff())
+}
+
+// gceInstance parses the provider ID of a GCE instance.
+func gceInstance(providerID string) (*providerid.Instance, error) {
+	instance, err := providerid.Parse(providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if instance.Provider != providerid.GCE {
+		return nil, fmt.Errorf("expected %s provider, got %s", providerid.GCE, instance.Provider)
+	}
+	return instance, nil
+}
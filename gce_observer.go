@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/mikkeloscar/kube-node-ready-controller/pkg/gce"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// getInstanceMaxElapsedTime is the maximum time rate limited or failed
+	// cloud instance requests are retried.
+	getInstanceMaxElapsedTime = 2 * time.Minute
+)
+
+// GCENodeStartUpObserver is a node startup duration observer which determines
+// the startup time duration based on the creation time of the GCE instance.
+type GCENodeStartUpObserver struct {
+	*instanceNodeStartUpObserver
+	compute    gce.ComputeAPI
+	newBackOff func() backoff.BackOff
+}
+
+// NewGCENodeStartUpObserver registers a prometheus histogram vec with the
+// given buckets and returns a GCENodeStartUpObserver. The histogram is
+// limited to maxSeries label combinations. Observed nodes are annotated
+// using the client.
+func NewGCENodeStartUpObserver(compute gce.ComputeAPI, client kubernetes.Interface, buckets []float64, maxSeries int) (*GCENodeStartUpObserver, error) {
+	startUpDurationSeconds, err := registerStartUpDurationHistogram(buckets)
+	if err != nil {
+		return nil, err
+	}
+
+	observer := newGCENodeStartUpObserver(compute, client, startUpDurationSeconds, maxSeries)
+	go observer.run()
+	return observer, nil
+}
+
+// newGCENodeStartUpObserver initializes a new GCENodeStartUpObserver without
+// starting to process the queue.
+func newGCENodeStartUpObserver(compute gce.ComputeAPI, client kubernetes.Interface, startUpDurationSeconds *prometheus.HistogramVec, maxSeries int) *GCENodeStartUpObserver {
+	observer := &GCENodeStartUpObserver{
+		compute:    compute,
+		newBackOff: newGetInstanceBackOff,
+	}
+	observer.instanceNodeStartUpObserver = newInstanceNodeStartUpObserver(startUpObserverGCE, observer.lookup, client, startUpDurationSeconds, maxSeries)
+	return observer
+}
+
+// lookup returns the creation time and the startup label values of the GCE
+// instance of the node. Rate limited and failed requests are retried with
+// exponential backoff.
+func (o *GCENodeStartUpObserver) lookup(node *v1.Node) (time.Time, []string, error) {
+	instance, err := gceInstance(node.Spec.ProviderID)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+
+	var computeInstance *gce.Instance
+	getInstance := func() error {
+		var err error
+		computeInstance, err = o.compute.GetInstance(instance.Project, instance.Zone, instance.ID)
+		if apiErr, ok := err.(*gce.Error); ok && !retryableStatus(apiErr.StatusCode) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+
+	err = backoff.Retry(getInstance, o.newBackOff())
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+
+	return computeInstance.CreationTimestamp, gceStartUpLabelValues(computeInstance, node), nil
+}
+
+// newGetInstanceBackOff returns the backoff used to retry cloud instance
+// requests.
+func newGetInstanceBackOff() backoff.BackOff {
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = getInstanceMaxElapsedTime
+	return b
+}
+
+// retryableStatus returns true if a request failing with the HTTP status
+// code should be retried.
+func retryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// gceStartUpLabelValues returns the machine type, zone, managed instance
+// group and lifecycle of the node. The values are taken from the GCE instance
+// and fall back to the node labels.
+func gceStartUpLabelValues(instance *gce.Instance, node *v1.Node) []string {
+	machineType := instance.MachineTypeName()
+	if machineType == "" {
+		machineType = nodeLabel(node, instanceTypeNodeLabels)
+	}
+
+	zone := instance.ZoneName()
+	if zone == "" {
+		zone = nodeLabel(node, zoneNodeLabels)
+	}
+
+	nodeGroup := instance.InstanceGroupManager()
+	if nodeGroup == "" {
+		nodeGroup = nodeLabel(node, nodeGroupNodeLabels)
+	}
+
+	lifecycle := lifecycleOnDemand
+	if instance.Spot() {
+		lifecycle = lifecycleSpot
+	}
+
+	return []string{machineType, zone, nodeGroup, lifecycle}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/mikkeloscar/kube-node-ready-controller/pkg/gce"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type mockCompute struct {
+	instances map[string]*gce.Instance
+	conflicts int
+	// unavailable fails the next requests with 503.
+	unavailable int
+	calls       int
+}
+
+func (c *mockCompute) GetInstance(project, zone, name string) (*gce.Instance, error) {
+	c.calls++
+	if c.unavailable > 0 {
+		c.unavailable--
+		return nil, &gce.Error{StatusCode: http.StatusServiceUnavailable, Message: "unavailable"}
+	}
+
+	instance, ok := c.instances[name]
+	if !ok {
+		return nil, &gce.Error{StatusCode: http.StatusNotFound, Message: "not found"}
+	}
+	return instance, nil
+}
+
+func (c *mockCompute) SetInstanceLabels(project, zone, name string, labels map[string]string, fingerprint string) error {
+	if c.conflicts > 0 {
+		c.conflicts--
+		return &gce.Error{StatusCode: http.StatusPreconditionFailed, Message: "fingerprint mismatch"}
+	}
+	c.instances[name].Labels = labels
+	return nil
+}
+
+func TestGCEInstanceLabelHook(t *testing.T) {
+	compute := &mockCompute{
+		instances: map[string]*gce.Instance{
+			"node-1": {Name: "node-1", Labels: map[string]string{"team": "a"}},
+		},
+		conflicts: 1,
+	}
+
+	hook := NewGCEInstanceLabelHook(compute, "ready", "true")
+	hook.newBackOff = func() backoff.BackOff {
+		return &backoff.ZeroBackOff{}
+	}
+
+	err := hook.Trigger("gce://project/europe-west1-b/node-1")
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	expected := map[string]string{"team": "a", "ready": "true"}
+	if !reflect.DeepEqual(compute.instances["node-1"].Labels, expected) {
+		t.Errorf("expected labels %v, got %v", expected, compute.instances["node-1"].Labels)
+	}
+
+	for _, providerID := range []string{"gce://project/europe-west1-b/node-2", "aws:///eu-central-1a/i-1234"} {
+		err = hook.Trigger(providerID)
+		if err == nil {
+			t.Errorf("expected failure for %s", providerID)
+		}
+	}
+}
+
+func TestGCEInstanceLabelHookRetry(t *testing.T) {
+	compute := &mockCompute{
+		instances: map[string]*gce.Instance{
+			"node-1": {Name: "node-1"},
+		},
+		unavailable: 1,
+	}
+
+	hook := NewGCEInstanceLabelHook(compute, "ready", "true")
+	hook.newBackOff = func() backoff.BackOff {
+		return &backoff.ZeroBackOff{}
+	}
+
+	err := hook.Trigger("gce://project/europe-west1-b/node-1")
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	if compute.calls != 2 {
+		t.Errorf("expected the failed request to be retried once, got %d calls", compute.calls)
+	}
+
+	if compute.instances["node-1"].Labels["ready"] != "true" {
+		t.Errorf("expected label to be set, got %v", compute.instances["node-1"].Labels)
+	}
+}
+
+func TestGCENodeStartUpObserver(t *testing.T) {
+	now := time.Now().UTC()
+	node := func(name string) v1.Node {
+		return v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				CreationTimestamp: metav1.NewTime(now.Add(-time.Minute)),
+			},
+			Spec: v1.NodeSpec{
+				ProviderID: "gce://project/europe-west1-b/" + name,
+			},
+		}
+	}
+
+	instance := &gce.Instance{
+		Name:              "a",
+		Zone:              "projects/project/zones/europe-west1-b",
+		MachineType:       "zones/europe-west1-b/machineTypes/n1-standard-4",
+		CreationTimestamp: now.Add(-2 * time.Minute),
+	}
+	compute := &mockCompute{instances: map[string]*gce.Instance{"a": instance}}
+
+	a, b := node("a"), node("b")
+	observer := newGCENodeStartUpObserver(
+		compute,
+		setupMockKubernetes(t, &a, nil),
+		prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "startup"}, startUpLabels),
+		10,
+	)
+	observer.newBackOff = func() backoff.BackOff {
+		return &backoff.ZeroBackOff{}
+	}
+
+	observer.observe(NodeStartUp{Node: a, Ready: now})
+	observer.observe(NodeStartUp{Node: b, Ready: now})
+
+	if !observer.observed.observed(&a) || observer.observed.observed(&b) {
+		t.Error("expected only node a to be observed")
+	}
+
+	// instances not found should not be retried.
+	if compute.calls != 2 {
+		t.Errorf("expected 2 calls, got %d", compute.calls)
+	}
+
+	labels := gceStartUpLabelValues(instance, &a)
+	expected := []string{"n1-standard-4", "europe-west1-b", "", lifecycleOnDemand}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Errorf("expected labels %v, got %v", expected, labels)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// maxMissedIntervals is the number of intervals without a completed
+	// pass after which the controller loop is considered unhealthy, or
+	// without a successful pass after which it's considered not ready.
+	maxMissedIntervals = 5
+)
+
+// health tracks the state of the controller loop exposed by the health
+// endpoints.
+type health struct {
+	sync.RWMutex
+	started      time.Time
+	lastAttempt  time.Time
+	lastSuccess  time.Time
+	configLoaded bool
+}
+
+// Healthy returns an error if the controller loop has not completed a pass
+// within maxMissedIntervals intervals, e.g. because it's stuck on an API call.
+// Passes failing with an error still count as progress as restarting the
+// controller doesn't fix e.g. an invalid config.
+func (n *NodeController) Healthy(now time.Time) error {
+	n.health.RLock()
+	defer n.health.RUnlock()
+
+	last := n.health.lastAttempt
+	if last.IsZero() {
+		last = n.health.started
+	}
+
+	if last.IsZero() {
+		return nil
+	}
+
+	maxAge := time.Duration(maxMissedIntervals) * n.interval
+	if now.Sub(last) > maxAge {
+		return fmt.Errorf("no completed pass since %s", last.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Ready returns an error if the controller has not loaded its config or has
+// not completed a successful pass within maxMissedIntervals intervals.
+func (n *NodeController) Ready(now time.Time) error {
+	n.health.RLock()
+	defer n.health.RUnlock()
+
+	if n.configMap != "" && !n.health.configLoaded {
+		return fmt.Errorf("config not loaded from config map '%s'", n.configMap)
+	}
+
+	if n.health.lastSuccess.IsZero() {
+		return fmt.Errorf("no successful pass yet")
+	}
+
+	maxAge := time.Duration(maxMissedIntervals) * n.interval
+	if now.Sub(n.health.lastSuccess) > maxAge {
+		return fmt.Errorf("no successful pass since %s", n.health.lastSuccess.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// healthHandler returns an http handler responding with 200 if the check
+// succeeds and with 503 otherwise.
+func healthHandler(check func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := check()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}
+}
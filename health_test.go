@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthy(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		msg         string
+		started     time.Time
+		lastAttempt time.Time
+		healthy     bool
+	}{
+		{
+			msg:     "controller should be healthy before it's started",
+			healthy: true,
+		},
+		{
+			msg:     "controller should be healthy shortly after start",
+			started: now.Add(-time.Minute),
+			healthy: true,
+		},
+		{
+			msg:     "controller should be unhealthy if first pass is stuck",
+			started: now.Add(-time.Hour),
+			healthy: false,
+		},
+		{
+			msg:         "controller should be healthy after recent pass",
+			started:     now.Add(-time.Hour),
+			lastAttempt: now.Add(-time.Minute),
+			healthy:     true,
+		},
+		{
+			msg:         "controller should be unhealthy without recent pass",
+			started:     now.Add(-2 * time.Hour),
+			lastAttempt: now.Add(-time.Hour),
+			healthy:     false,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			controller := &NodeController{interval: time.Minute}
+			controller.health.started = tc.started
+			controller.health.lastAttempt = tc.lastAttempt
+
+			err := controller.Healthy(now)
+			if err != nil && tc.healthy {
+				t.Errorf("should not fail: %s", err)
+			}
+
+			if err == nil && !tc.healthy {
+				t.Error("expected failure")
+			}
+		})
+	}
+}
+
+func TestReady(t *testing.T) {
+	now := time.Now()
+	controller := &NodeController{configMap: "config", interval: time.Minute}
+	if controller.Ready(now) == nil {
+		t.Error("expected failure before config is loaded")
+	}
+
+	controller.health.configLoaded = true
+	if controller.Ready(now) == nil {
+		t.Error("expected failure before first successful pass")
+	}
+
+	controller.health.lastSuccess = now.Add(-time.Minute)
+	err := controller.Ready(now)
+	if err != nil {
+		t.Errorf("should not fail: %s", err)
+	}
+
+	controller.health.lastSuccess = now.Add(-time.Hour)
+	if controller.Ready(now) == nil {
+		t.Error("expected failure without recent successful pass")
+	}
+}
+
+func TestHealthPersistentConfigError(t *testing.T) {
+	controller := &NodeController{
+		Interface: setupMockKubernetes(t, nil, nil),
+		configMap: "config",
+		namespace: namespace,
+		interval:  time.Minute,
+	}
+	controller.health.started = time.Now().Add(-time.Hour)
+
+	for i := 0; i < 3; i++ {
+		controller.runPass()
+	}
+
+	err := controller.Healthy(time.Now())
+	if err != nil {
+		t.Errorf("should not fail: %s", err)
+	}
+
+	if controller.Ready(time.Now()) == nil {
+		t.Error("expected failure when the config can't be loaded")
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	for _, tc := range []struct {
+		msg    string
+		err    error
+		status int
+	}{
+		{
+			msg:    "should respond with 200 on success",
+			status: http.StatusOK,
+		},
+		{
+			msg:    "should respond with 503 on failure",
+			err:    fmt.Errorf("failed"),
+			status: http.StatusServiceUnavailable,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			healthHandler(func() error { return tc.err }).ServeHTTP(recorder, httptest.NewRequest("GET", "/healthz", nil))
+			if recorder.Code != tc.status {
+				t.Errorf("expected status %d, got %d", tc.status, recorder.Code)
+			}
+		})
+	}
+}
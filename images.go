@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/api/core/v1"
+)
+
+const (
+	defaultImageDomain = "docker.io"
+	defaultImageTag    = "latest"
+)
+
+// ImageRequirements defines container images which must be present on the
+// node before it's considered ready.
+type ImageRequirements struct {
+	// References are image references by tag or digest, e.g.
+	// registry.example.org/app:v1 or registry.example.org/app@sha256:...
+	References []string `yaml:"references"`
+	// PrePuller optionally selects the pods of a pre-puller DaemonSet which
+	// must be ready on the node in addition to the images being present.
+	PrePuller *PodSelector `yaml:"prePuller"`
+}
+
+// Name returns the name of the check.
+func (i *ImageRequirements) Name() string {
+	return "images"
+}
+
+// Evaluate checks if the required images are present on the node and the
+// pre-puller pods are ready.
+func (i *ImageRequirements) Evaluate(ctx context.Context, node *v1.Node, snapshot *NodeSnapshot) (*CheckResult, error) {
+	unmet := i.missing(node)
+
+	if i.PrePuller != nil {
+		result, err := i.PrePuller.Evaluate(ctx, node, snapshot)
+		if err != nil {
+			return nil, err
+		}
+
+		if !result.Ready {
+			unmet = append(unmet, fmt.Sprintf("pre-puller %s", result))
+		}
+	}
+
+	return newCheckResult(i, unmet), nil
+}
+
+// validate validates the image requirements.
+func (i *ImageRequirements) validate() error {
+	for _, ref := range i.References {
+		if ref == "" {
+			return fmt.Errorf("image reference must not be empty")
+		}
+	}
+
+	if i.PrePuller != nil {
+		return i.PrePuller.validate()
+	}
+
+	return nil
+}
+
+// missing returns a description of every required image not present on the
+// node.
+func (i *ImageRequirements) missing(node *v1.Node) []string {
+	if i == nil {
+		return nil
+	}
+
+	present := make(map[string]struct{})
+	for _, image := range node.Status.Images {
+		for _, name := range image.Names {
+			present[normalizeImageReference(name)] = struct{}{}
+		}
+	}
+
+	var missing []string
+	for _, ref := range i.References {
+		if _, ok := present[normalizeImageReference(ref)]; !ok {
+			missing = append(missing, fmt.Sprintf("image %s not present", ref))
+		}
+	}
+	return missing
+}
+
+// normalizeImageReference expands an image reference to its fully qualified
+// form such that e.g. nginx and docker.io/library/nginx:latest are equal. A
+// tag is dropped if the reference also has a digest as images are listed by
+// digest without the tag on the node.
+func normalizeImageReference(ref string) string {
+	name := ref
+	digest := ""
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, digest = name[:i], name[i:]
+	}
+
+	suffix := digest
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		if digest == "" {
+			suffix = name[i:]
+		}
+		name = name[:i]
+	}
+
+	if suffix == "" {
+		suffix = ":" + defaultImageTag
+	}
+
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 1 || (!strings.ContainsAny(parts[0], ".:") && parts[0] != "localhost") {
+		name = defaultImageDomain + "/" + name
+		parts = strings.SplitN(name, "/", 2)
+	}
+
+	if parts[0] == defaultImageDomain && !strings.Contains(parts[1], "/") {
+		name = defaultImageDomain + "/library/" + parts[1]
+	}
+
+	return name + suffix
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNormalizeImageReference(t *testing.T) {
+	for _, tc := range []struct {
+		ref        string
+		normalized string
+	}{
+		{"nginx", "docker.io/library/nginx:latest"},
+		{"nginx:1.19", "docker.io/library/nginx:1.19"},
+		{"docker.io/library/nginx:1.19", "docker.io/library/nginx:1.19"},
+		{"team/app:v1", "docker.io/team/app:v1"},
+		{"registry.example.org/team/app:v1", "registry.example.org/team/app:v1"},
+		{"registry.example.org:5000/app", "registry.example.org:5000/app:latest"},
+		{"localhost/app:v1", "localhost/app:v1"},
+		{"nginx@sha256:abc", "docker.io/library/nginx@sha256:abc"},
+		{"nginx:1.19@sha256:abc", "docker.io/library/nginx@sha256:abc"},
+		{"registry.example.org:5000/app:v1@sha256:abc", "registry.example.org:5000/app@sha256:abc"},
+	} {
+		t.Run(tc.ref, func(t *testing.T) {
+			normalized := normalizeImageReference(tc.ref)
+			if normalized != tc.normalized {
+				t.Errorf("expected %s, got %s", tc.normalized, normalized)
+			}
+		})
+	}
+}
+
+func TestImageRequirementsMissing(t *testing.T) {
+	node := &v1.Node{
+		Status: v1.NodeStatus{
+			Images: []v1.ContainerImage{
+				{
+					Names: []string{
+						"registry.example.org/app@sha256:abc",
+						"registry.example.org/app:v1",
+					},
+				},
+				{
+					Names: []string{"docker.io/library/nginx:1.19"},
+				},
+			},
+		},
+	}
+
+	requirements := &ImageRequirements{
+		References: []string{
+			"registry.example.org/app:v1",
+			"registry.example.org/app@sha256:abc",
+			"nginx:1.19",
+			"registry.example.org/app:v2",
+		},
+	}
+
+	expected := []string{"image registry.example.org/app:v2 not present"}
+	missing := requirements.missing(node)
+	if !reflect.DeepEqual(missing, expected) {
+		t.Errorf("expected %v, got %v", expected, missing)
+	}
+
+	var noRequirements *ImageRequirements
+	if len(noRequirements.missing(node)) != 0 {
+		t.Error("expected no missing images without requirements")
+	}
+}
+
+func TestImageRequirementsEvaluate(t *testing.T) {
+	// images pulled by digest are listed by digest without the tag.
+	node := &v1.Node{
+		Status: v1.NodeStatus{
+			Images: []v1.ContainerImage{
+				{
+					Names: []string{"registry.example.org/app@sha256:abc"},
+				},
+			},
+		},
+	}
+
+	prePuller := v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "kube-system",
+			Labels:    map[string]string{"app": "pre-puller"},
+		},
+		Status: v1.PodStatus{
+			Conditions: []v1.PodCondition{
+				{
+					Type:   v1.PodReady,
+					Status: v1.ConditionTrue,
+				},
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		msg   string
+		ref   string
+		pods  []v1.Pod
+		ready bool
+	}{
+		{
+			msg:   "tag and digest should match the image listed by digest",
+			ref:   "registry.example.org/app:v1@sha256:abc",
+			pods:  []v1.Pod{prePuller},
+			ready: true,
+		},
+		{
+			msg:   "different digest should not match",
+			ref:   "registry.example.org/app:v1@sha256:def",
+			pods:  []v1.Pod{prePuller},
+			ready: false,
+		},
+		{
+			msg:   "pre-puller pod should be required",
+			ref:   "registry.example.org/app:v1@sha256:abc",
+			ready: false,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			requirements := &ImageRequirements{
+				References: []string{tc.ref},
+				PrePuller: &PodSelector{
+					Namespace: "kube-system",
+					Labels:    map[string]string{"app": "pre-puller"},
+				},
+			}
+
+			result, err := requirements.Evaluate(context.Background(), node, &NodeSnapshot{Now: time.Now(), Pods: tc.pods})
+			if err != nil {
+				t.Fatalf("should not fail: %s", err)
+			}
+
+			if result.Ready != tc.ready {
+				t.Errorf("expected ready %t, got %t: %s", tc.ready, result.Ready, result.Reason)
+			}
+		})
+	}
+}
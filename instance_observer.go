@@ -0,0 +1,93 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// instanceLookup returns the launch time and the startup label values of the
+// cloud instance of a node.
+type instanceLookup func(node *v1.Node) (launchTime time.Time, labels []string, err error)
+
+// instanceNodeStartUpObserver is a node startup duration observer which
+// determines the startup time duration based on the launch time of the cloud
+// instance of a node. The instances are looked up from a queue to not block
+// the caller.
+type instanceNodeStartUpObserver struct {
+	lookup                 instanceLookup
+	observed               *observedNodes
+	startUpDurationSeconds *prometheus.HistogramVec
+	series                 *seriesGuard
+	queue                  chan NodeStartUp
+}
+
+// newInstanceNodeStartUpObserver initializes a new
+// instanceNodeStartUpObserver for the named observer without starting to
+// process the queue.
+func newInstanceNodeStartUpObserver(name string, lookup instanceLookup, client kubernetes.Interface, startUpDurationSeconds *prometheus.HistogramVec, maxSeries int) *instanceNodeStartUpObserver {
+	return &instanceNodeStartUpObserver{
+		lookup:                 lookup,
+		observed:               newObservedNodes(client, name),
+		startUpDurationSeconds: startUpDurationSeconds,
+		series:                 newSeriesGuard(maxSeries),
+		queue:                  make(chan NodeStartUp, observerQueueSize),
+	}
+}
+
+// run processes the queued observations.
+func (o *instanceNodeStartUpObserver) run() {
+	for startUp := range o.queue {
+		o.observe(startUp)
+	}
+}
+
+// ObserveNode queues the observation of the node startup to not block the
+// caller. If the queue is full the observation is dropped.
+func (o *instanceNodeStartUpObserver) ObserveNode(startUp NodeStartUp) {
+	if o.observed.observed(&startUp.Node) {
+		log.Infof("Ignoring node %s already observed", startUp.Node.Name)
+		return
+	}
+
+	select {
+	case o.queue <- startUp:
+	default:
+		log.Warnf("Dropping startup observation of node %s, queue is full", startUp.Node.Name)
+	}
+}
+
+// GarbageCollect forgets the observed nodes which no longer exist.
+func (o *instanceNodeStartUpObserver) GarbageCollect(nodes []v1.Node) {
+	o.observed.GarbageCollect(nodes)
+}
+
+// observe observes the node startup time duration based on the launch time
+// of the underlying instance as well as the duration from launch until node
+// registration.
+func (o *instanceNodeStartUpObserver) observe(startUp NodeStartUp) {
+	node := startUp.Node
+	now := startUp.Ready.UTC()
+
+	// the node could have been queued more than once.
+	if o.observed.observed(&node) {
+		return
+	}
+
+	launchTime, labels, err := o.lookup(&node)
+	if err != nil {
+		log.Errorf("Failed to get node launch time of node %s: %v", node.Name, err)
+		return
+	}
+
+	o.startUpDurationSeconds.WithLabelValues(o.series.labels(labels)...).Observe(now.Sub(launchTime).Seconds())
+	if !node.CreationTimestamp.IsZero() {
+		startUpPhaseDuration.WithLabelValues(phaseLaunchToRegistration).Observe(node.CreationTimestamp.Sub(launchTime).Seconds())
+	}
+
+	// record that node was observed
+	o.observed.markObserved(&node, now)
+}
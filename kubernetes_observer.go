@@ -0,0 +1,105 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// KubernetesNodeStartUpObserver is a node startup duration observer which
+// determines the startup time duration based on the timestamps of the node
+// object. Unlike the ASGNodeStartUpObserver it doesn't depend on a cloud
+// provider.
+type KubernetesNodeStartUpObserver struct {
+	observed                    *observedNodes
+	registrationDurationSeconds prometheus.Histogram
+	kubeletReadyDurationSeconds prometheus.Histogram
+}
+
+// NewKubernetesNodeStartUpObserver registers the prometheus histograms and
+// returns a KubernetesNodeStartUpObserver. Observed nodes are annotated using
+// the client.
+func NewKubernetesNodeStartUpObserver(client kubernetes.Interface) (*KubernetesNodeStartUpObserver, error) {
+	registrationDurationSeconds := prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:      "startup_registration_duration_seconds",
+			Help:      "Time from node registration until the node is marked ready in seconds.",
+			Subsystem: "node",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+		},
+	)
+
+	kubeletReadyDurationSeconds := prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:      "startup_kubelet_ready_duration_seconds",
+			Help:      "Time from the kubelet reporting the node Ready until the node is marked ready in seconds.",
+			Subsystem: "node",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+		},
+	)
+
+	for _, collector := range []prometheus.Collector{registrationDurationSeconds, kubeletReadyDurationSeconds} {
+		err := prometheus.Register(collector)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &KubernetesNodeStartUpObserver{
+		observed:                    newObservedNodes(client, startUpObserverKubernetes),
+		registrationDurationSeconds: registrationDurationSeconds,
+		kubeletReadyDurationSeconds: kubeletReadyDurationSeconds,
+	}, nil
+}
+
+// ObserveNode observes the time from node registration and from the kubelet
+// Ready condition transition until the node was marked ready.
+func (o *KubernetesNodeStartUpObserver) ObserveNode(startUp NodeStartUp) {
+	node := startUp.Node
+	if o.observed.observed(&node) {
+		log.Infof("Ignoring node %s already observed", node.Name)
+		return
+	}
+
+	ready := startUp.Ready.UTC()
+	registered := node.CreationTimestamp.Time
+
+	if !registered.IsZero() {
+		o.registrationDurationSeconds.Observe(ready.Sub(registered).Seconds())
+	}
+
+	condition := nodeCondition(&node, v1.NodeReady)
+	if condition != nil && condition.Status == v1.ConditionTrue {
+		kubeletReady := condition.LastTransitionTime.Time
+		o.kubeletReadyDurationSeconds.Observe(ready.Sub(kubeletReady).Seconds())
+	}
+
+	// record that node was observed
+	o.observed.markObserved(&node, ready)
+}
+
+// GarbageCollect forgets the observed nodes which no longer exist.
+func (o *KubernetesNodeStartUpObserver) GarbageCollect(nodes []v1.Node) {
+	o.observed.GarbageCollect(nodes)
+}
+
+// NodeStartUpObservers is a list of observers which all observe a node.
+type NodeStartUpObservers []NodeStartUpObserver
+
+// ObserveNode observes the node with all observers.
+func (o NodeStartUpObservers) ObserveNode(startUp NodeStartUp) {
+	for _, observer := range o {
+		observer.ObserveNode(startUp)
+	}
+}
+
+// GarbageCollect forgets the nodes which no longer exist in all observers
+// keeping state about nodes.
+func (o NodeStartUpObservers) GarbageCollect(nodes []v1.Node) {
+	for _, observer := range o {
+		if gc, ok := observer.(nodeGarbageCollector); ok {
+			gc.GarbageCollect(nodes)
+		}
+	}
+}
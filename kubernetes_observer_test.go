@@ -0,0 +1,172 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func histogramSample(t *testing.T, histogram prometheus.Histogram) (uint64, float64) {
+	var metric dto.Metric
+	err := histogram.Write(&metric)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+	return metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum()
+}
+
+func TestKubernetesNodeStartUpObserver(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 10, 0, 0, time.UTC)
+	observer := &KubernetesNodeStartUpObserver{
+		observed:                    newObservedNodes(nil, startUpObserverKubernetes),
+		registrationDurationSeconds: prometheus.NewHistogram(prometheus.HistogramOpts{Name: "registration"}),
+		kubeletReadyDurationSeconds: prometheus.NewHistogram(prometheus.HistogramOpts{Name: "kubelet_ready"}),
+	}
+
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "foo",
+			CreationTimestamp: metav1.NewTime(now.Add(-5 * time.Minute)),
+		},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{
+				{
+					Type:               v1.NodeReady,
+					Status:             v1.ConditionTrue,
+					LastTransitionTime: metav1.NewTime(now.Add(-2 * time.Minute)),
+				},
+			},
+		},
+	}
+
+	startUp := NodeStartUp{
+		Node: node,
+		SelectorsReady: map[string]time.Time{
+			"kube-system:application=kube2iam": now.Add(-time.Minute),
+		},
+		Ready: now,
+	}
+
+	observer.ObserveNode(startUp)
+	// a node should only be observed once.
+	observer.ObserveNode(startUp)
+
+	count, sum := histogramSample(t, observer.registrationDurationSeconds)
+	if count != 1 || sum != 300 {
+		t.Errorf("expected one registration observation of 300s, got %d with sum %v", count, sum)
+	}
+
+	count, sum = histogramSample(t, observer.kubeletReadyDurationSeconds)
+	if count != 1 || sum != 120 {
+		t.Errorf("expected one kubelet ready observation of 120s, got %d with sum %v", count, sum)
+	}
+}
+
+func TestSelectorsReady(t *testing.T) {
+	now := time.Now()
+	ready := selectorsReady([]*StageReadiness{
+		{
+			Stage: &Stage{Name: "default"},
+			Readiness: &NodeReadiness{
+				Results: []*CheckResult{
+					{Check: "required", Ready: true, ReadySince: now},
+					{Check: "optional", Ready: true, Optional: true, ReadySince: now},
+					{Check: "not-ready", Blocking: true},
+				},
+			},
+		},
+		{Stage: &Stage{Name: "not-evaluated"}},
+	})
+
+	if len(ready) != 1 || !ready["required"].Equal(now) {
+		t.Errorf("expected only required selector to be ready, got %v", ready)
+	}
+}
+
+func TestFirstReadyTimes(t *testing.T) {
+	now := time.Now()
+	var times firstReadyTimes
+
+	times.update("foo", map[string]time.Time{"selector": now.Add(-time.Minute)})
+	// the pod flapped, moving the transition time.
+	ready := times.update("foo", map[string]time.Time{"selector": now, "other": now})
+	if !ready["selector"].Equal(now.Add(-time.Minute)) || !ready["other"].Equal(now) {
+		t.Errorf("expected earliest ready times, got %v", ready)
+	}
+
+	times.GarbageCollect([]v1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "bar"}}})
+	ready = times.update("foo", map[string]time.Time{"selector": now})
+	if !ready["selector"].Equal(now) {
+		t.Errorf("expected ready times of deleted node to be forgotten, got %v", ready)
+	}
+}
+
+func TestSetNodeReadyObservesPhases(t *testing.T) {
+	now := time.Now()
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "foo",
+			CreationTimestamp: metav1.NewTime(now.Add(-5 * time.Minute)),
+		},
+		Spec: v1.NodeSpec{
+			Taints: []v1.Taint{{Key: taintNodeNotReadyName}},
+		},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{
+				{
+					Type:               v1.NodeReady,
+					Status:             v1.ConditionTrue,
+					LastTransitionTime: metav1.NewTime(now.Add(-2 * time.Minute)),
+				},
+			},
+		},
+	}
+
+	phase := startUpPhaseDuration.WithLabelValues(phaseRegistrationToKubeletReady).(prometheus.Histogram)
+	selector := selectorReadySeconds.WithLabelValues("phases-test").(prometheus.Histogram)
+	phaseBefore, _ := histogramSample(t, phase)
+	selectorBefore, _ := histogramSample(t, selector)
+
+	client := setupMockKubernetes(t, node, nil)
+	selectorsReady := map[string]time.Time{"phases-test": now.Add(-time.Minute)}
+
+	// no startup observer is configured.
+	controller := &NodeController{
+		Interface:             client,
+		startUpPhasesObserved: newObservedNodes(client, startUpPhasesObserver),
+	}
+
+	err := controller.setNodeReady(node, map[string]bool{taintNodeNotReadyName: true}, selectorsReady)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	// the node flaps and is marked ready again, also after a restart of
+	// the controller.
+	for _, c := range []*NodeController{controller, {
+		Interface:             client,
+		startUpPhasesObserved: newObservedNodes(client, startUpPhasesObserver),
+	}} {
+		err = c.setNodeReady(node, map[string]bool{taintNodeNotReadyName: false}, nil)
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+
+		err = c.setNodeReady(node, map[string]bool{taintNodeNotReadyName: true}, selectorsReady)
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+	}
+
+	if count, _ := histogramSample(t, phase); count != phaseBefore+1 {
+		t.Errorf("expected one kubelet ready phase observation, got %d", count-phaseBefore)
+	}
+
+	if count, _ := histogramSample(t, selector); count != selectorBefore+1 {
+		t.Errorf("expected one selector observation, got %d", count-selectorBefore)
+	}
+}
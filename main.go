@@ -1,41 +1,85 @@
 package main
 
 import (
+	"fmt"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 	pkgAWS "github.com/mikkeloscar/kube-node-ready-controller/pkg/aws"
+	"github.com/mikkeloscar/kube-node-ready-controller/pkg/azure"
+	"github.com/mikkeloscar/kube-node-ready-controller/pkg/gce"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/alecthomas/kingpin.v2"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 const (
 	defaultInterval              = "15s"
 	defaultMetricsAddress        = ":7979"
 	defaultTaintNodeNotReadyName = "node.alpha.kubernetes.io/notReady-workload"
+	startUpObserverAWS           = "aws"
+	startUpObserverKubernetes    = "kubernetes"
+	startUpObserverGCE           = "gce"
+	startUpObserverAzure         = "azure"
+	startUpObserverClusterAPI    = "cluster-api"
+	defaultNodeStartUpMaxSeries  = "200"
+	// defaultNotReadyAnnotationTimeout bounds the time nodes which never
+	// become ready are kept from being scaled down.
+	defaultNotReadyAnnotationTimeout = "1h"
 )
 
 var (
 	config struct {
-		Interval                 time.Duration
-		MetricsAddress           string
-		PodSelectors             PodSelectors
-		NodeSelectors            Labels
-		ConfigMap                string
-		ASGLifecycleHook         string
-		EnableNodeStartUpMetrics bool
-		TaintNodeNotReadyName    string
-		APIServer                *url.URL
+		Interval                  time.Duration
+		MetricsAddress            string
+		PodSelectors              PodSelectors
+		NodeSelectors             Labels
+		NodeConditions            NodeConditionRequirements
+		NodeAllocatable           ResourceRequirements
+		CSIDrivers                CSIDriverRequirements
+		RequiredImages            []string
+		ConfigMap                 string
+		ASGLifecycleHook          string
+		AWSRegion                 string
+		AWSMetadataRegion         bool
+		AWSRoleARN                string
+		AWSExternalID             string
+		AWSRoleSessionName        string
+		AWSWebIdentityTokenFile   string
+		AWSAutoscalingEndpoint    string
+		AWSEC2Endpoint            string
+		GCEInstanceReadyLabel     string
+		AzureVMReadyTag           string
+		AzureClientID             string
+		ClusterAPIMachineHook     bool
+		ClusterAPISkipRemediation time.Duration
+		ClusterAPIVersion         string
+		EnableNodeStartUpMetrics  bool
+		NodeStartUpObservers      []string
+		NodeStartUpBuckets        []float64
+		NodeStartUpMaxSeries      int
+		TaintNodeNotReadyName     string
+		AutoscalerAnnotations     bool
+		NotReadyAnnotations       map[string]string
+		NotReadyAnnotationTimeout time.Duration
+		DryRun                    bool
+		APIServer                 *url.URL
+		KubeConfig                string
+		KubeContext               string
+		Namespace                 string
 	}
 )
 
@@ -43,57 +87,130 @@ func init() {
 	kingpin.Flag("interval", "Interval between checks.").
 		Default(defaultInterval).DurationVar(&config.Interval)
 	kingpin.Flag("apiserver", "API server url.").URLVar(&config.APIServer)
-	kingpin.Flag("metrics-address", "defines where to serve metrics").
+	kingpin.Flag("kubeconfig", "Path to a kubeconfig file. Defaults to the KUBECONFIG environment variable, ~/.kube/config or the in-cluster config.").
+		StringVar(&config.KubeConfig)
+	kingpin.Flag("context", "Name of the kubeconfig context to use.").
+		StringVar(&config.KubeContext)
+	kingpin.Flag("namespace", "Namespace of the pod selector configMap. Defaults to the namespace of the service account.").
+		StringVar(&config.Namespace)
+	kingpin.Flag("metrics-address", "defines where to serve metrics and the health endpoints").
 		Default(defaultMetricsAddress).StringVar(&config.MetricsAddress)
 	kingpin.Flag("pod-selector", "Pod selector specified by <namespace>:<key>=<value>,+.").
 		SetValue(&config.PodSelectors)
 	kingpin.Flag("node-selector", "Node selector labels <key>=<value>,+.").
 		SetValue(&config.NodeSelectors)
+	kingpin.Flag("node-condition", "Node condition required for a node to be ready <type>[=<status>]. Status defaults to True.").
+		SetValue(&config.NodeConditions)
+	kingpin.Flag("node-allocatable", "Resource which must be allocatable for a node to be ready <resource>=<quantity>.").
+		SetValue(&config.NodeAllocatable)
+	kingpin.Flag("csi-driver", "CSI driver which must be registered in the CSINode of a node for it to be ready <name>[:<topologyKey>,+].").
+		SetValue(&config.CSIDrivers)
+	kingpin.Flag("required-image", "Image reference which must be present on a node for it to be ready.").
+		StringsVar(&config.RequiredImages)
 	kingpin.Flag("pod-selector-configmap", "Name of configMap with pod selector definition. Must be in the same namespace.").
 		StringVar(&config.ConfigMap)
 	kingpin.Flag("asg-lifecycle-hook", "Name of ASG lifecycle hook to trigger on node Ready.").
 		StringVar(&config.ASGLifecycleHook)
+	kingpin.Flag("aws-region", "AWS region. Defaults to the region of the environment or the ec2 metadata service.").
+		StringVar(&config.AWSRegion)
+	kingpin.Flag("aws-metadata-region", "Get the AWS region from the ec2 metadata service if not configured. Use --no-aws-metadata-region to disable when not running on ec2.").
+		Default("true").BoolVar(&config.AWSMetadataRegion)
+	kingpin.Flag("aws-role-arn", "ARN of an AWS IAM role to assume.").
+		StringVar(&config.AWSRoleARN)
+	kingpin.Flag("aws-external-id", "External ID used when assuming the AWS IAM role.").
+		StringVar(&config.AWSExternalID)
+	kingpin.Flag("aws-role-session-name", "Session name used when assuming an AWS IAM role.").
+		Default(pkgAWS.DefaultRoleSessionName).StringVar(&config.AWSRoleSessionName)
+	kingpin.Flag("aws-web-identity-token-file", "Path to a web identity token to assume the role of AWS_ROLE_ARN with. Defaults to AWS_WEB_IDENTITY_TOKEN_FILE as set for IAM roles for service accounts.").
+		StringVar(&config.AWSWebIdentityTokenFile)
+	kingpin.Flag("aws-autoscaling-endpoint", "Custom endpoint URL of the AWS autoscaling API.").
+		StringVar(&config.AWSAutoscalingEndpoint)
+	kingpin.Flag("aws-ec2-endpoint", "Custom endpoint URL of the AWS ec2 API.").
+		StringVar(&config.AWSEC2Endpoint)
+	kingpin.Flag("gce-instance-ready-label", "Label <key>=<value> to set on the GCE instance on node Ready.").
+		StringVar(&config.GCEInstanceReadyLabel)
+	kingpin.Flag("azure-vm-ready-tag", "Tag <key>=<value> to set on the Azure VM on node Ready. Scale set instances (e.g. AKS nodes) are skipped.").
+		StringVar(&config.AzureVMReadyTag)
+	kingpin.Flag("azure-client-id", "Client ID of the user assigned managed identity used for the Azure API. Defaults to the system assigned identity.").
+		StringVar(&config.AzureClientID)
+	kingpin.Flag("cluster-api-machine-hook", "Set the NodeWorkloadReady condition on the Cluster API Machine of a node on node Ready.").
+		BoolVar(&config.ClusterAPIMachineHook)
+	kingpin.Flag("cluster-api-skip-remediation-timeout", "Skip MachineHealthCheck remediation of the Machines of not ready nodes until the timeout has elapsed since node creation. Requires --cluster-api-machine-hook.").
+		DurationVar(&config.ClusterAPISkipRemediation)
+	kingpin.Flag("cluster-api-version", "Version of the Cluster API Machine resource.").
+		Default(defaultClusterAPIVersion).StringVar(&config.ClusterAPIVersion)
 	kingpin.Flag("enable-node-startup-metrics", "Enable node startup duration metrics.").
 		BoolVar(&config.EnableNodeStartUpMetrics)
+	kingpin.Flag("node-startup-observer", "Node startup observer to enable: 'aws' (ec2 instance launch time, same as --enable-node-startup-metrics), 'gce' (GCE instance creation time), 'azure' (Azure VM creation time), 'cluster-api' (Cluster API Machine creation time) or 'kubernetes' (node registration and kubelet Ready time).").
+		EnumsVar(&config.NodeStartUpObservers, startUpObserverAWS, startUpObserverGCE, startUpObserverAzure, startUpObserverClusterAPI, startUpObserverKubernetes)
+	kingpin.Flag("node-startup-bucket", "Histogram bucket in seconds of the cloud instance node startup duration. Can be repeated.").
+		Float64ListVar(&config.NodeStartUpBuckets)
+	kingpin.Flag("node-startup-max-series", "Maximum number of label combinations of the cloud instance node startup duration. Further combinations are observed with the label values 'other'.").
+		Default(defaultNodeStartUpMaxSeries).IntVar(&config.NodeStartUpMaxSeries)
 	kingpin.Flag("not-ready-taint-name", "Name of the taint set for not ready nodes.").
-		StringVar(&config.TaintNodeNotReadyName)
+		Default(defaultTaintNodeNotReadyName).StringVar(&config.TaintNodeNotReadyName)
+	kingpin.Flag("autoscaler-annotations", "Annotate not ready nodes such that cluster-autoscaler and Karpenter don't scale them down until they are ready.").
+		BoolVar(&config.AutoscalerAnnotations)
+	kingpin.Flag("not-ready-annotation", "Annotation <key>=<value> to set on not ready nodes until they are ready. Can be repeated.").
+		StringMapVar(&config.NotReadyAnnotations)
+	kingpin.Flag("not-ready-annotation-timeout", "Remove the not ready annotations once the timeout has elapsed since node creation even if the node is not ready. 0 disables the timeout.").
+		Default(defaultNotReadyAnnotationTimeout).DurationVar(&config.NotReadyAnnotationTimeout)
+	kingpin.Flag("dry-run", "Only log, record events to stdout and expose metrics for the taint changes and hooks which would have been made.").
+		BoolVar(&config.DryRun)
 }
 
 func main() {
 	kingpin.Parse()
 
+	if config.EnableNodeStartUpMetrics {
+		config.NodeStartUpObservers = append(config.NodeStartUpObservers, startUpObserverAWS)
+	}
+
 	var awsSession *session.Session
 	var err error
-	if config.ASGLifecycleHook != "" || config.EnableNodeStartUpMetrics {
-		awsSession, err = pkgAWS.Session(aws.NewConfig())
+	if config.ASGLifecycleHook != "" || containsString(config.NodeStartUpObservers, startUpObserverAWS) {
+		awsSession, err = pkgAWS.NewSession(aws.NewConfig(), awsSessionOptions())
 		if err != nil {
 			log.Fatalf("Failed to setup aws Session: %v", err)
 		}
 	}
 
+	var gceCompute gce.ComputeAPI
+	if config.GCEInstanceReadyLabel != "" || containsString(config.NodeStartUpObservers, startUpObserverGCE) {
+		gceCompute = gce.NewClient()
+	}
+
+	var azureCompute azure.ComputeAPI
+	if config.AzureVMReadyTag != "" || containsString(config.NodeStartUpObservers, startUpObserverAzure) {
+		azureClient := azure.NewClient()
+		azureClient.ClientID = config.AzureClientID
+		azureCompute = azureClient
+	}
+
 	var hooks []Hook
 	if config.ASGLifecycleHook != "" {
 		hooks = append(hooks, NewASGLifecycleHook(awsSession, config.ASGLifecycleHook))
 	}
 
-	var startupObserver NodeStartUpObserver
-	if config.EnableNodeStartUpMetrics {
-		startupObserver, err = NewASGNodeStartUpObserver(awsSession)
+	if config.GCEInstanceReadyLabel != "" {
+		key, value, err := parseKeyValue(config.GCEInstanceReadyLabel)
 		if err != nil {
-			log.Fatalf("Failed to setup observer: %v", err)
+			log.Fatalf("Invalid GCE instance ready label: %v", err)
 		}
+		hooks = append(hooks, NewGCEInstanceLabelHook(gceCompute, key, value))
 	}
 
-	var kubeConfig *rest.Config
-	if config.APIServer != nil {
-		kubeConfig = &rest.Config{
-			Host: config.APIServer.String(),
-		}
-	} else {
-		kubeConfig, err = rest.InClusterConfig()
+	if config.AzureVMReadyTag != "" {
+		key, value, err := parseKeyValue(config.AzureVMReadyTag)
 		if err != nil {
-			log.Fatal(err)
+			log.Fatalf("Invalid Azure VM ready tag: %v", err)
 		}
+		hooks = append(hooks, NewAzureVMTagHook(azureCompute, key, value))
+	}
+
+	kubeConfig, err := newKubeConfig(config.APIServer, config.KubeConfig, config.KubeContext)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	// set timeouts for kube client
@@ -122,21 +239,68 @@ func main() {
 		}
 	}()
 
+	// the TLS options must be set on the custom transport as the client
+	// doesn't allow both.
+	tr.TLSClientConfig, err = rest.TLSConfigFor(kubeConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	kubeConfig.TLSClientConfig = rest.TLSClientConfig{}
 	kubeConfig.Transport = tr
 	client, err := kubernetes.NewForConfig(kubeConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	dynamicClient, err := dynamic.NewForConfig(kubeConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	machines := NewMachineClient(dynamicClient, config.ClusterAPIVersion)
+	if config.ClusterAPIMachineHook {
+		hooks = append(hooks, NewClusterAPIMachineHook(machines, config.ClusterAPISkipRemediation))
+	}
+
+	startupObserver, err := newNodeStartUpObserver(config.NodeStartUpObservers, awsSession, gceCompute, azureCompute, machines, client)
+	if err != nil {
+		log.Fatalf("Failed to setup observer: %v", err)
+	}
+
+	readinessConfig := &Config{
+		Selectors:  config.PodSelectors,
+		CSIDrivers: config.CSIDrivers,
+	}
+
+	if len(config.NodeConditions) > 0 || len(config.NodeAllocatable) > 0 {
+		readinessConfig.Prerequisites = &NodePrerequisites{
+			Conditions:  config.NodeConditions,
+			Allocatable: config.NodeAllocatable,
+		}
+	}
+
+	if len(config.RequiredImages) > 0 {
+		readinessConfig.Images = &ImageRequirements{References: config.RequiredImages}
+	}
+
+	stages, err := readinessConfig.ReadinessStages(config.TaintNodeNotReadyName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	controller, err := NewNodeController(
 		client,
-		config.PodSelectors,
+		stages,
 		config.NodeSelectors,
 		config.TaintNodeNotReadyName,
 		config.Interval,
 		config.ConfigMap,
+		config.Namespace,
 		hooks,
 		startupObserver,
+		notReadyAnnotations(config.NotReadyAnnotations, config.AutoscalerAnnotations),
+		config.NotReadyAnnotationTimeout,
+		config.DryRun,
 	)
 	if err != nil {
 		log.Fatal(err)
@@ -144,11 +308,111 @@ func main() {
 
 	go handleSigterm(stopChan)
 
-	go serveMetrics(config.MetricsAddress)
+	go serveHTTP(config.MetricsAddress, controller)
 
 	controller.Run(stopChan)
 }
 
+// newNodeStartUpObserver returns an observer observing nodes with each of the
+// named observers or nil if none are named.
+func newNodeStartUpObserver(names []string, awsSession *session.Session, gceCompute gce.ComputeAPI, azureCompute azure.ComputeAPI, machines MachineClient, client kubernetes.Interface) (NodeStartUpObserver, error) {
+	var observers NodeStartUpObservers
+	enabled := make(map[string]bool, len(names))
+	for _, name := range names {
+		if enabled[name] {
+			continue
+		}
+		enabled[name] = true
+
+		var observer NodeStartUpObserver
+		var err error
+		switch name {
+		case startUpObserverAWS:
+			observer, err = NewASGNodeStartUpObserver(awsSession, client, config.NodeStartUpBuckets, config.NodeStartUpMaxSeries)
+		case startUpObserverGCE:
+			observer, err = NewGCENodeStartUpObserver(gceCompute, client, config.NodeStartUpBuckets, config.NodeStartUpMaxSeries)
+		case startUpObserverAzure:
+			observer, err = NewAzureNodeStartUpObserver(azureCompute, client, config.NodeStartUpBuckets, config.NodeStartUpMaxSeries)
+		case startUpObserverClusterAPI:
+			observer, err = NewClusterAPINodeStartUpObserver(machines, client, config.NodeStartUpBuckets, config.NodeStartUpMaxSeries)
+		case startUpObserverKubernetes:
+			observer, err = NewKubernetesNodeStartUpObserver(client)
+		}
+		if err != nil {
+			return nil, err
+		}
+		observers = append(observers, observer)
+	}
+
+	if len(observers) == 0 {
+		return nil, nil
+	}
+	return observers, nil
+}
+
+// awsSessionOptions returns the AWS session options configured by the flags.
+func awsSessionOptions() pkgAWS.SessionOptions {
+	opts := pkgAWS.SessionOptions{
+		Region:                config.AWSRegion,
+		DisableMetadataRegion: !config.AWSMetadataRegion,
+		RoleARN:               config.AWSRoleARN,
+		ExternalID:            config.AWSExternalID,
+		RoleSessionName:       config.AWSRoleSessionName,
+		WebIdentityTokenFile:  config.AWSWebIdentityTokenFile,
+		Endpoints:             make(map[string]string),
+	}
+
+	if config.AWSAutoscalingEndpoint != "" {
+		opts.Endpoints[endpoints.AutoscalingServiceID] = config.AWSAutoscalingEndpoint
+	}
+
+	if config.AWSEC2Endpoint != "" {
+		opts.Endpoints[endpoints.Ec2ServiceID] = config.AWSEC2Endpoint
+	}
+	return opts
+}
+
+// notReadyAnnotations returns the annotations to set on not ready nodes
+// including the autoscaler annotations if enabled.
+func notReadyAnnotations(annotations map[string]string, autoscaler bool) map[string]string {
+	merged := make(map[string]string, len(annotations)+len(autoscalerAnnotations))
+	if autoscaler {
+		for key, value := range autoscalerAnnotations {
+			merged[key] = value
+		}
+	}
+
+	for key, value := range annotations {
+		merged[key] = value
+	}
+	return merged
+}
+
+// parseKeyValue parses a <key>=<value> pair.
+func parseKeyValue(value string) (string, string, error) {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return "", "", fmt.Errorf("invalid format %s, expected <key>=<value>", value)
+	}
+	return kv[0], kv[1], nil
+}
+
+// newKubeConfig returns the client config for the API server url if defined.
+// Otherwise the config is loaded from the kubeconfig using the default
+// loading rules, falling back to the in-cluster config.
+func newKubeConfig(apiServer *url.URL, kubeconfig, context string) (*rest.Config, error) {
+	if apiServer != nil {
+		return &rest.Config{
+			Host: apiServer.String(),
+		}, nil
+	}
+
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: context}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+}
+
 func handleSigterm(stopChan chan struct{}) {
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGTERM)
@@ -157,7 +421,13 @@ func handleSigterm(stopChan chan struct{}) {
 	close(stopChan)
 }
 
-func serveMetrics(address string) {
+func serveHTTP(address string, controller *NodeController) {
 	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/healthz", healthHandler(func() error {
+		return controller.Healthy(time.Now())
+	}))
+	http.Handle("/readyz", healthHandler(func() error {
+		return controller.Ready(time.Now())
+	}))
 	log.Fatal(http.ListenAndServe(address, nil))
 }
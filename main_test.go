@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestNotReadyTaintNameDefault(t *testing.T) {
+	_, err := kingpin.CommandLine.Parse(nil)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	if config.TaintNodeNotReadyName != defaultTaintNodeNotReadyName {
+		t.Errorf("expected default taint %s, got %s", defaultTaintNodeNotReadyName, config.TaintNodeNotReadyName)
+	}
+
+	// the default stage must be valid without any flags set.
+	_, err = (&Config{}).ReadinessStages(config.TaintNodeNotReadyName)
+	if err != nil {
+		t.Errorf("should not fail: %s", err)
+	}
+}
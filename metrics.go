@@ -0,0 +1,215 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	optionalSelectorFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "optional_selector_failures_total",
+			Help:      "Number of nodes marked ready while an optional selector was not satisfied.",
+			Subsystem: "node",
+		},
+		[]string{"selector"},
+	)
+	dryRunTaintDecisions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "dry_run_taint_decisions_total",
+			Help:      "Number of taint changes which would have been made in dry-run mode.",
+			Subsystem: "node",
+		},
+		[]string{"action", "taint"},
+	)
+	dryRunBlockingChecks = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "dry_run_blocking_checks_total",
+			Help:      "Number of times a check kept a node not ready in dry-run mode.",
+			Subsystem: "node",
+		},
+		[]string{"check"},
+	)
+	readinessNodes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name:      "readiness_nodes",
+			Help:      "Number of nodes by readiness state per taint in the last successful pass.",
+			Subsystem: "node",
+		},
+		[]string{"taint", "state"},
+	)
+	checkNotReadyNodes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name:      "check_not_ready_nodes",
+			Help:      "Number of nodes for which a check was not ready in the last successful pass.",
+			Subsystem: "node",
+		},
+		[]string{"check"},
+	)
+	taintChanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "taint_changes_total",
+			Help:      "Number of taints added to or removed from nodes.",
+			Subsystem: "node",
+		},
+		[]string{"action", "taint"},
+	)
+	reconcileDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:      "reconcile_duration_seconds",
+			Help:      "Duration of a pass over all nodes in seconds.",
+			Subsystem: "node",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+		},
+	)
+	apiErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "api_errors_total",
+			Help:      "Number of failed Kubernetes API requests by verb.",
+			Subsystem: "node",
+		},
+		[]string{"verb"},
+	)
+	hookInvocations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "hook_invocations_total",
+			Help:      "Number of triggered node ready hooks.",
+			Subsystem: "node",
+		},
+		[]string{"hook"},
+	)
+	hookFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "hook_failures_total",
+			Help:      "Number of node ready hooks which failed.",
+			Subsystem: "node",
+		},
+		[]string{"hook"},
+	)
+	hookDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:      "hook_duration_seconds",
+			Help:      "Duration of triggering node ready hooks in seconds.",
+			Subsystem: "node",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"hook"},
+	)
+	configReloads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "config_reloads_total",
+			Help:      "Number of config reloads from the config map by result.",
+			Subsystem: "node",
+		},
+		[]string{"result"},
+	)
+	startUpPhaseDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:      "startup_phase_duration_seconds",
+			Help:      "Duration of the phases of the node startup in seconds.",
+			Subsystem: "node",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+		},
+		[]string{"phase"},
+	)
+	selectorReadySeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:      "selector_ready_seconds",
+			Help:      "Time from node registration until the pods of a selector were ready in seconds.",
+			Subsystem: "node",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+		},
+		[]string{"selector"},
+	)
+	lastPassSuccess = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name:      "last_pass_success_timestamp_seconds",
+			Help:      "Timestamp of the last completed pass over all nodes.",
+			Subsystem: "node",
+		},
+	)
+	configLastReload = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name:      "config_last_reload_success_timestamp_seconds",
+			Help:      "Timestamp of the last successful config reload from the config map.",
+			Subsystem: "node",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(optionalSelectorFailures)
+	prometheus.MustRegister(dryRunTaintDecisions)
+	prometheus.MustRegister(dryRunBlockingChecks)
+	prometheus.MustRegister(readinessNodes)
+	prometheus.MustRegister(checkNotReadyNodes)
+	prometheus.MustRegister(taintChanges)
+	prometheus.MustRegister(reconcileDuration)
+	prometheus.MustRegister(lastPassSuccess)
+	prometheus.MustRegister(apiErrors)
+	prometheus.MustRegister(hookInvocations)
+	prometheus.MustRegister(hookFailures)
+	prometheus.MustRegister(hookDuration)
+	prometheus.MustRegister(configReloads)
+	prometheus.MustRegister(configLastReload)
+	prometheus.MustRegister(startUpPhaseDuration)
+	prometheus.MustRegister(selectorReadySeconds)
+}
+
+// readinessStats collects the readiness of the nodes handled in a single
+// pass.
+type readinessStats struct {
+	nodes    map[[2]string]float64
+	notReady map[string]float64
+}
+
+// newReadinessStats initializes a new readinessStats.
+func newReadinessStats() *readinessStats {
+	return &readinessStats{
+		nodes:    make(map[[2]string]float64),
+		notReady: make(map[string]float64),
+	}
+}
+
+// add adds the readiness of the stages of a node.
+func (s *readinessStats) add(stages []*StageReadiness) {
+	for _, stage := range stages {
+		state := "not_ready"
+		if stage.Ready() {
+			state = "ready"
+		}
+		s.nodes[[2]string{stage.Stage.Taint, state}]++
+
+		if stage.Readiness == nil {
+			continue
+		}
+
+		for _, result := range stage.Readiness.Results {
+			if !result.Ready {
+				s.notReady[result.Check]++
+			} else if _, ok := s.notReady[result.Check]; !ok {
+				s.notReady[result.Check] = 0
+			}
+		}
+	}
+}
+
+// publish sets the readiness gauges to the collected stats.
+func (s *readinessStats) publish() {
+	readinessNodes.Reset()
+	for key, count := range s.nodes {
+		readinessNodes.WithLabelValues(key[0], key[1]).Set(count)
+	}
+
+	checkNotReadyNodes.Reset()
+	for check, count := range s.notReady {
+		checkNotReadyNodes.WithLabelValues(check).Set(count)
+	}
+}
+
+// recordAPIError increments the API error metric for the verb if err is
+// not nil.
+func recordAPIError(verb string, err error) {
+	if err != nil {
+		apiErrors.WithLabelValues(verb).Inc()
+	}
+}
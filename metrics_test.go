@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestReadinessStats(t *testing.T) {
+	network := &Stage{Name: "network", Taint: "network"}
+	storage := &Stage{Name: "storage", Taint: "storage"}
+
+	stats := newReadinessStats()
+	stats.add([]*StageReadiness{
+		{
+			Stage: network,
+			Readiness: &NodeReadiness{
+				Results: []*CheckResult{{Check: "cni", Ready: true}},
+			},
+		},
+		{
+			Stage: storage,
+			Readiness: &NodeReadiness{
+				Results: []*CheckResult{{Check: "csi", Blocking: true}},
+			},
+		},
+	})
+	stats.add([]*StageReadiness{
+		{
+			Stage: network,
+			Readiness: &NodeReadiness{
+				Results: []*CheckResult{{Check: "cni", Blocking: true}},
+			},
+		},
+		{Stage: storage},
+	})
+
+	for key, expected := range map[[2]string]float64{
+		{"network", "ready"}:     1,
+		{"network", "not_ready"}: 1,
+		{"storage", "not_ready"}: 2,
+	} {
+		if stats.nodes[key] != expected {
+			t.Errorf("expected %v nodes for %v, got %v", expected, key, stats.nodes[key])
+		}
+	}
+
+	if stats.notReady["cni"] != 1 || stats.notReady["csi"] != 1 {
+		t.Errorf("expected 1 not ready node per check, got %v", stats.notReady)
+	}
+
+	stats.publish()
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// NodeConditionRequirement requires a node condition to have an expected
+// status.
+type NodeConditionRequirement struct {
+	Type   v1.NodeConditionType `yaml:"type"`
+	Status v1.ConditionStatus   `yaml:"status"`
+}
+
+// status returns the expected status of the condition. Defaults to True.
+func (c NodeConditionRequirement) status() v1.ConditionStatus {
+	if c.Status == "" {
+		return v1.ConditionTrue
+	}
+	return c.Status
+}
+
+// NodeConditionRequirements is a list of NodeConditionRequirement
+// definitions.
+type NodeConditionRequirements []NodeConditionRequirement
+
+func (c NodeConditionRequirements) String() string {
+	strs := make([]string, 0, len(c))
+	for _, condition := range c {
+		strs = append(strs, fmt.Sprintf("%s=%s", condition.Type, condition.status()))
+	}
+	return strings.Join(strs, ",")
+}
+
+// Set parses a node condition requirement in the format <type>[=<status>]
+// and adds it to the list.
+func (c *NodeConditionRequirements) Set(value string) error {
+	kv := strings.Split(value, "=")
+	if len(kv) > 2 || kv[0] == "" {
+		return fmt.Errorf("invalid node condition format")
+	}
+
+	condition := NodeConditionRequirement{Type: v1.NodeConditionType(kv[0])}
+	if len(kv) == 2 {
+		condition.Status = v1.ConditionStatus(kv[1])
+	}
+
+	err := condition.validate()
+	if err != nil {
+		return err
+	}
+
+	*c = append(*c, condition)
+	return nil
+}
+
+// IsCumulative always return true because it's allowed to call Set multiple
+// times.
+func (c NodeConditionRequirements) IsCumulative() bool {
+	return true
+}
+
+// validate validates the expected condition status.
+func (c NodeConditionRequirement) validate() error {
+	switch c.status() {
+	case v1.ConditionTrue, v1.ConditionFalse, v1.ConditionUnknown:
+		return nil
+	default:
+		return fmt.Errorf("invalid status '%s' for node condition '%s'", c.Status, c.Type)
+	}
+}
+
+// ResourceRequirement requires a minimum allocatable quantity of a resource
+// on the node.
+type ResourceRequirement struct {
+	Name     v1.ResourceName
+	Quantity resource.Quantity
+}
+
+// UnmarshalYAML unmarshals a resource requirement in the format:
+//
+// name: nvidia.com/gpu
+// quantity: 1
+func (r *ResourceRequirement) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw struct {
+		Name     string `yaml:"name"`
+		Quantity string `yaml:"quantity"`
+	}
+
+	err := unmarshal(&raw)
+	if err != nil {
+		return err
+	}
+
+	quantity, err := resource.ParseQuantity(raw.Quantity)
+	if err != nil {
+		return fmt.Errorf("invalid quantity for resource '%s': %v", raw.Name, err)
+	}
+
+	r.Name = v1.ResourceName(raw.Name)
+	r.Quantity = quantity
+	return nil
+}
+
+// ResourceRequirements is a list of ResourceRequirement definitions.
+type ResourceRequirements []ResourceRequirement
+
+func (r ResourceRequirements) String() string {
+	strs := make([]string, 0, len(r))
+	for _, requirement := range r {
+		strs = append(strs, fmt.Sprintf("%s=%s", requirement.Name, requirement.Quantity.String()))
+	}
+	return strings.Join(strs, ",")
+}
+
+// Set parses a resource requirement in the format <name>=<quantity> and adds
+// it to the list.
+func (r *ResourceRequirements) Set(value string) error {
+	kv := strings.Split(value, "=")
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("invalid resource requirement format")
+	}
+
+	quantity, err := resource.ParseQuantity(kv[1])
+	if err != nil {
+		return fmt.Errorf("invalid quantity for resource '%s': %v", kv[0], err)
+	}
+
+	*r = append(*r, ResourceRequirement{Name: v1.ResourceName(kv[0]), Quantity: quantity})
+	return nil
+}
+
+// IsCumulative always return true because it's allowed to call Set multiple
+// times.
+func (r ResourceRequirements) IsCumulative() bool {
+	return true
+}
+
+// NodePrerequisites defines node level requirements which must be met before
+// a node is considered ready.
+type NodePrerequisites struct {
+	// Conditions are node conditions which must have the expected status.
+	Conditions NodeConditionRequirements `yaml:"conditions"`
+	// Allocatable are resources which must be allocatable on the node with
+	// at least the specified quantity.
+	Allocatable ResourceRequirements `yaml:"allocatable"`
+	// Labels must be present on the node. An empty value only requires the
+	// label key to be present.
+	Labels map[string]string `yaml:"labels"`
+	// Annotations must be present on the node. An empty value only
+	// requires the annotation key to be present.
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// validate validates the node prerequisites.
+func (p *NodePrerequisites) validate() error {
+	for _, condition := range p.Conditions {
+		if condition.Type == "" {
+			return fmt.Errorf("node condition type must be specified")
+		}
+
+		err := condition.validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, requirement := range p.Allocatable {
+		if requirement.Name == "" {
+			return fmt.Errorf("resource name must be specified")
+		}
+	}
+
+	return nil
+}
+
+// Name returns the name of the check.
+func (p *NodePrerequisites) Name() string {
+	return "nodePrerequisites"
+}
+
+// Evaluate checks if the node meets the node prerequisites.
+func (p *NodePrerequisites) Evaluate(ctx context.Context, node *v1.Node, snapshot *NodeSnapshot) (*CheckResult, error) {
+	return newCheckResult(p, p.unmet(node)), nil
+}
+
+// unmet returns a description of every prerequisite not met by the node.
+func (p *NodePrerequisites) unmet(node *v1.Node) []string {
+	if p == nil {
+		return nil
+	}
+
+	var unmet []string
+	for _, expected := range p.Conditions {
+		condition := nodeCondition(node, expected.Type)
+		if condition == nil {
+			unmet = append(unmet, fmt.Sprintf("node condition %s missing", expected.Type))
+			continue
+		}
+
+		if condition.Status != expected.status() {
+			unmet = append(unmet, fmt.Sprintf("node condition %s is %s, expected %s", expected.Type, condition.Status, expected.status()))
+		}
+	}
+
+	for _, requirement := range p.Allocatable {
+		allocatable, ok := node.Status.Allocatable[requirement.Name]
+		if !ok {
+			unmet = append(unmet, fmt.Sprintf("resource %s not allocatable", requirement.Name))
+			continue
+		}
+
+		if allocatable.Cmp(requirement.Quantity) < 0 {
+			unmet = append(unmet, fmt.Sprintf("resource %s allocatable %s, expected at least %s", requirement.Name, allocatable.String(), requirement.Quantity.String()))
+		}
+	}
+
+	unmet = append(unmet, unmetKeyValues("label", node.ObjectMeta.Labels, p.Labels)...)
+	unmet = append(unmet, unmetKeyValues("annotation", node.ObjectMeta.Annotations, p.Annotations)...)
+
+	return unmet
+}
+
+// unmetKeyValues returns a description of every expected key/value not found
+// in values. An empty expected value only requires the key to be present.
+func unmetKeyValues(kind string, values, expected map[string]string) []string {
+	keys := make([]string, 0, len(expected))
+	for key := range expected {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var unmet []string
+	for _, key := range keys {
+		expectedValue := expected[key]
+		value, ok := values[key]
+		if !ok {
+			unmet = append(unmet, fmt.Sprintf("%s %s missing", kind, key))
+			continue
+		}
+
+		if expectedValue != "" && value != expectedValue {
+			unmet = append(unmet, fmt.Sprintf("%s %s is '%s', expected '%s'", kind, key, value, expectedValue))
+		}
+	}
+	return unmet
+}
+
+// nodeCondition returns the condition of the given type or nil if the node
+// doesn't have the condition.
+func nodeCondition(node *v1.Node, conditionType v1.NodeConditionType) *v1.NodeCondition {
+	for i, condition := range node.Status.Conditions {
+		if condition.Type == conditionType {
+			return &node.Status.Conditions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNodePrerequisitesUnmet(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      map[string]string{"foo": "bar"},
+			Annotations: map[string]string{"baz": "qux"},
+		},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{
+				{
+					Type:   v1.NodeReady,
+					Status: v1.ConditionTrue,
+				},
+				{
+					Type:   v1.NodeNetworkUnavailable,
+					Status: v1.ConditionTrue,
+				},
+			},
+			Allocatable: v1.ResourceList{
+				"nvidia.com/gpu": resource.MustParse("1"),
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		msg           string
+		prerequisites *NodePrerequisites
+		unmet         []string
+	}{
+		{
+			msg:           "no prerequisites should always be met",
+			prerequisites: nil,
+			unmet:         nil,
+		},
+		{
+			msg: "met prerequisites should not be reported",
+			prerequisites: &NodePrerequisites{
+				Conditions: NodeConditionRequirements{
+					{Type: v1.NodeReady},
+				},
+				Allocatable: ResourceRequirements{
+					{Name: "nvidia.com/gpu", Quantity: resource.MustParse("1")},
+				},
+				Labels:      map[string]string{"foo": "bar"},
+				Annotations: map[string]string{"baz": ""},
+			},
+			unmet: nil,
+		},
+		{
+			msg: "unmet prerequisites should be reported",
+			prerequisites: &NodePrerequisites{
+				Conditions: NodeConditionRequirements{
+					{Type: v1.NodeNetworkUnavailable, Status: v1.ConditionFalse},
+					{Type: v1.NodeMemoryPressure, Status: v1.ConditionFalse},
+				},
+				Allocatable: ResourceRequirements{
+					{Name: "nvidia.com/gpu", Quantity: resource.MustParse("2")},
+					{Name: "example.com/foo", Quantity: resource.MustParse("1")},
+				},
+				Labels:      map[string]string{"foo": "baz"},
+				Annotations: map[string]string{"missing": ""},
+			},
+			unmet: []string{
+				"node condition NetworkUnavailable is True, expected False",
+				"node condition MemoryPressure missing",
+				"resource nvidia.com/gpu allocatable 1, expected at least 2",
+				"resource example.com/foo not allocatable",
+				"label foo is 'bar', expected 'baz'",
+				"annotation missing missing",
+			},
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			unmet := tc.prerequisites.unmet(node)
+			if !reflect.DeepEqual(unmet, tc.unmet) {
+				t.Errorf("expected %v, got %v", tc.unmet, unmet)
+			}
+		})
+	}
+}
+
+func TestSetNodeConditionRequirements(t *testing.T) {
+	for _, tc := range []struct {
+		msg   string
+		value string
+		valid bool
+	}{
+		{
+			msg:   "test valid condition",
+			value: "Ready",
+			valid: true,
+		},
+		{
+			msg:   "test valid condition with status",
+			value: "NetworkUnavailable=False",
+			valid: true,
+		},
+		{
+			msg:   "test invalid condition status",
+			value: "Ready=Yes",
+			valid: false,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			var conditions NodeConditionRequirements
+			err := conditions.Set(tc.value)
+			if err != nil && tc.valid {
+				t.Errorf("should not fail: %s", err)
+			}
+
+			if err == nil && !tc.valid {
+				t.Error("expected failure")
+			}
+		})
+	}
+}
+
+func TestSetResourceRequirements(t *testing.T) {
+	for _, tc := range []struct {
+		msg   string
+		value string
+		valid bool
+	}{
+		{
+			msg:   "test valid resource",
+			value: "nvidia.com/gpu=1",
+			valid: true,
+		},
+		{
+			msg:   "test invalid resource without quantity",
+			value: "nvidia.com/gpu",
+			valid: false,
+		},
+		{
+			msg:   "test invalid resource quantity",
+			value: "nvidia.com/gpu=one",
+			valid: false,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			var resources ResourceRequirements
+			err := resources.Set(tc.value)
+			if err != nil && tc.valid {
+				t.Errorf("should not fail: %s", err)
+			}
+
+			if err == nil && !tc.valid {
+				t.Error("expected failure")
+			}
+		})
+	}
+}
+
+func TestReadConfigPrerequisites(t *testing.T) {
+	const data = `nodePrerequisites:
+  conditions:
+  - type: Ready
+  - type: NetworkUnavailable
+    status: "False"
+  allocatable:
+  - name: nvidia.com/gpu
+    quantity: 1
+  labels:
+    foo: bar`
+
+	config, err := ReadConfig(data)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	if len(config.Prerequisites.Conditions) != 2 {
+		t.Errorf("expected %d conditions, got %d", 2, len(config.Prerequisites.Conditions))
+	}
+
+	if len(config.Prerequisites.Allocatable) != 1 {
+		t.Fatalf("expected %d resources, got %d", 1, len(config.Prerequisites.Allocatable))
+	}
+
+	if config.Prerequisites.Allocatable[0].Quantity.Cmp(resource.MustParse("1")) != 0 {
+		t.Errorf("expected quantity 1, got %s", config.Prerequisites.Allocatable[0].Quantity.String())
+	}
+
+	const invalidData = `nodePrerequisites:
+  conditions:
+  - type: Ready
+    status: Yes`
+
+	_, err = ReadConfig(invalidData)
+	if err == nil {
+		t.Errorf("expected error")
+	}
+}
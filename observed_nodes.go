@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// observedAnnotationPrefix is the prefix of the node annotation
+	// recording when the startup of the node was observed by an observer.
+	observedAnnotationPrefix = "kube-node-ready-controller/startup-observed-"
+)
+
+// observedNodes tracks which nodes have been observed by a node startup
+// observer. Observations are persisted as an annotation on the node such that
+// nodes are not observed again after a restart. The in-memory state is
+// garbage collected when nodes are deleted.
+type observedNodes struct {
+	client     kubernetes.Interface
+	annotation string
+	nodes      sync.Map
+}
+
+// newObservedNodes initializes a new observedNodes for the named observer.
+// If client is nil observations are only tracked in memory.
+func newObservedNodes(client kubernetes.Interface, observer string) *observedNodes {
+	return &observedNodes{
+		client:     client,
+		annotation: observedAnnotationPrefix + observer,
+	}
+}
+
+// observed returns true if the node has already been observed.
+func (o *observedNodes) observed(node *v1.Node) bool {
+	if _, ok := o.nodes.Load(node.Name); ok {
+		return true
+	}
+
+	if _, ok := node.Annotations[o.annotation]; ok {
+		o.nodes.Store(node.Name, nil)
+		return true
+	}
+	return false
+}
+
+// markObserved records that the node was observed at the given time.
+func (o *observedNodes) markObserved(node *v1.Node, observed time.Time) {
+	o.nodes.Store(node.Name, nil)
+
+	if o.client == nil {
+		return
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				o.annotation: observed.UTC().Format(time.RFC3339),
+			},
+		},
+	})
+	if err != nil {
+		log.Errorf("Failed to create patch for node %s: %v", node.Name, err)
+		return
+	}
+
+	_, err = o.client.CoreV1().Nodes().Patch(node.Name, types.MergePatchType, patch)
+	recordAPIError("patch", err)
+	if err != nil {
+		log.Errorf("Failed to annotate node %s as observed: %v", node.Name, err)
+	}
+}
+
+// GarbageCollect removes the nodes which no longer exist from memory.
+func (o *observedNodes) GarbageCollect(nodes []v1.Node) {
+	existing := make(map[string]struct{}, len(nodes))
+	for _, node := range nodes {
+		existing[node.Name] = struct{}{}
+	}
+
+	o.nodes.Range(func(key, _ interface{}) bool {
+		if _, ok := existing[key.(string)]; !ok {
+			o.nodes.Delete(key)
+		}
+		return true
+	})
+}
+
+// nodeGarbageCollector describes a node startup observer or hook which keeps
+// state about nodes which must be cleaned up once the nodes are deleted.
+type nodeGarbageCollector interface {
+	GarbageCollect(nodes []v1.Node)
+}
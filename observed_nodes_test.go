@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestObservedNodes(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "foo",
+		},
+	}
+
+	client := setupMockKubernetes(t, node, nil)
+	observed := newObservedNodes(client, "test")
+
+	if observed.observed(node) {
+		t.Error("expected node to not be observed")
+	}
+
+	observed.markObserved(node, time.Now())
+	if !observed.observed(node) {
+		t.Error("expected node to be observed")
+	}
+
+	n, err := client.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	// a new instance, e.g. after a restart, should consider the annotated
+	// node observed.
+	restarted := newObservedNodes(client, "test")
+	if !restarted.observed(n) {
+		t.Errorf("expected annotated node to be observed, got annotations %v", n.Annotations)
+	}
+
+	if newObservedNodes(client, "other").observed(n) {
+		t.Error("expected node to not be observed by other observer")
+	}
+
+	observed.GarbageCollect(nil)
+	if _, ok := observed.nodes.Load(node.Name); ok {
+		t.Error("expected deleted node to be garbage collected")
+	}
+}
@@ -1,104 +1,545 @@
 package main
 
 import (
-	"fmt"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/cenkalti/backoff"
+	"github.com/mikkeloscar/kube-node-ready-controller/pkg/providerid"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
 )
 
 // NodeStartUpObserver describes an observer which can observe the startup
 // time duration of a node.
 type NodeStartUpObserver interface {
-	ObserveNode(node v1.Node)
+	ObserveNode(startUp NodeStartUp)
 }
 
+// NodeStartUp describes the startup of a node which was marked ready.
+type NodeStartUp struct {
+	// Node is the node marked ready.
+	Node v1.Node
+	// SelectorsReady is the time each required selector became ready,
+	// keyed by the selector name.
+	SelectorsReady map[string]time.Time
+	// Ready is the time the node was marked ready, i.e. the time the
+	// taints were removed.
+	Ready time.Time
+}
+
+const (
+	phaseLaunchToRegistration       = "launch_to_registration"
+	phaseRegistrationToKubeletReady = "registration_to_kubelet_ready"
+	// startUpPhasesObserver is the name under which nodes are recorded as
+	// observed once their startup phases were observed by the controller.
+	startUpPhasesObserver = "phases"
+)
+
+// observeStartUpPhases observes the time from node registration until the
+// kubelet reported the node Ready and until each required selector was ready.
+// Unlike the launch phase these don't depend on the cloud provider and are
+// observed regardless of the startup observer. The time from the kubelet
+// reporting Ready until the node was marked ready is observed by the
+// kubernetes observer.
+func observeStartUpPhases(startUp NodeStartUp) {
+	node := startUp.Node
+	registered := node.CreationTimestamp.Time
+
+	if !registered.IsZero() {
+		for selector, selectorReady := range startUp.SelectorsReady {
+			selectorReadySeconds.WithLabelValues(selector).Observe(selectorReady.Sub(registered).Seconds())
+		}
+	}
+
+	condition := nodeCondition(&node, v1.NodeReady)
+	if condition != nil && condition.Status == v1.ConditionTrue && !registered.IsZero() {
+		kubeletReady := condition.LastTransitionTime.Time
+		startUpPhaseDuration.WithLabelValues(phaseRegistrationToKubeletReady).Observe(kubeletReady.Sub(registered).Seconds())
+	}
+}
+
+const (
+	lifecycleSpot     = "spot"
+	lifecycleOnDemand = "on-demand"
+	// otherLabelValue replaces the label values of observations exceeding
+	// the maximum number of series.
+	otherLabelValue = "other"
+	// asgNameTag is the tag set by AWS on instances launched by an ASG.
+	asgNameTag = "aws:autoscaling:groupName"
+	// observerBatchInterval is the maximum time to wait for further startup
+	// observations to describe the instances in a single batch.
+	observerBatchInterval = 5 * time.Second
+	// maxDescribeInstanceIDs is the maximum number of instance IDs described
+	// in a single request.
+	maxDescribeInstanceIDs = 1000
+	// describeInstancesMaxElapsedTime is the maximum time throttled and
+	// failed requests are retried.
+	describeInstancesMaxElapsedTime = 2 * time.Minute
+	// observerQueueSize is the maximum number of queued startup
+	// observations.
+	observerQueueSize = 1000
+)
+
+var (
+	// DefaultNodeStartUpBuckets are the default histogram buckets in
+	// seconds used for the node startup duration.
+	DefaultNodeStartUpBuckets = []float64{30, 60, 90, 120, 150, 180, 240, 300, 420, 600, 900, 1200}
+
+	// startUpLabels are the labels of the node startup duration histogram.
+	startUpLabels = []string{"instance_type", "zone", "node_group", "lifecycle"}
+
+	// instanceIDPattern matches the ec2 instance IDs named in error
+	// messages.
+	instanceIDPattern = regexp.MustCompile(`i-[0-9a-zA-Z]+`)
+
+	// node labels used to derive the startup labels if not available from
+	// the cloud instance.
+	instanceTypeNodeLabels = []string{"node.kubernetes.io/instance-type", "beta.kubernetes.io/instance-type"}
+	zoneNodeLabels         = []string{"topology.kubernetes.io/zone", "failure-domain.beta.kubernetes.io/zone"}
+	nodeGroupNodeLabels    = []string{"eks.amazonaws.com/nodegroup", "alpha.eksctl.io/nodegroup-name", "cloud.google.com/gke-nodepool", "kubernetes.azure.com/agentpool"}
+	lifecycleNodeLabels    = []string{"node.kubernetes.io/lifecycle", "eks.amazonaws.com/capacityType", "kubernetes.azure.com/scalesetpriority"}
+)
+
 // ASGNodeStartUpObserver is a node startup duration oberserver which determines
 // the statup time duration based on ec2 instance launch time.
 type ASGNodeStartUpObserver struct {
 	ec2Client              ec2iface.EC2API
-	nodesObserved         sync.Map
-	startUpDurationSeconds prometheus.Summary
-}
-
-// NewASGNodeStartUpObserver registers a prometheus summary vec and returns a
-// ASGNodeStartUpObserver.
-func NewASGNodeStartUpObserver(sess *session.Session) (*ASGNodeStartUpObserver, error) {
-	startUpDurationSeconds := prometheus.NewSummary(
-		prometheus.SummaryOpts{
-			Name:       "startup_duration_seconds",
-			Help:       "The node startup latencies in seconds.",
-			Subsystem:  "node",
-			Objectives: prometheus.DefObjectives,
+	observed               *observedNodes
+	startUpDurationSeconds *prometheus.HistogramVec
+	series                 *seriesGuard
+	queue                  chan NodeStartUp
+	batchInterval          time.Duration
+	instances              map[string]*ec2.Instance
+	instancesMutex         sync.Mutex
+	newBackOff             func() backoff.BackOff
+}
+
+// NewASGNodeStartUpObserver registers a prometheus histogram vec with the
+// given buckets and returns a ASGNodeStartUpObserver. The histogram is
+// limited to maxSeries label combinations. Observed nodes are annotated
+// using the client.
+func NewASGNodeStartUpObserver(sess *session.Session, client kubernetes.Interface, buckets []float64, maxSeries int) (*ASGNodeStartUpObserver, error) {
+	startUpDurationSeconds, err := registerStartUpDurationHistogram(buckets)
+	if err != nil {
+		return nil, err
+	}
+
+	observer := newASGNodeStartUpObserver(ec2.New(sess), client, startUpDurationSeconds, maxSeries)
+	go observer.run()
+	return observer, nil
+}
+
+// newASGNodeStartUpObserver initializes a new ASGNodeStartUpObserver without
+// starting to process the queue.
+func newASGNodeStartUpObserver(ec2Client ec2iface.EC2API, client kubernetes.Interface, startUpDurationSeconds *prometheus.HistogramVec, maxSeries int) *ASGNodeStartUpObserver {
+	return &ASGNodeStartUpObserver{
+		ec2Client:              ec2Client,
+		observed:               newObservedNodes(client, startUpObserverAWS),
+		startUpDurationSeconds: startUpDurationSeconds,
+		series:                 newSeriesGuard(maxSeries),
+		queue:                  make(chan NodeStartUp, observerQueueSize),
+		batchInterval:          observerBatchInterval,
+		instances:              make(map[string]*ec2.Instance),
+		newBackOff: func() backoff.BackOff {
+			b := backoff.NewExponentialBackOff()
+			b.MaxElapsedTime = describeInstancesMaxElapsedTime
+			return b
+		},
+	}
+}
+
+// registerStartUpDurationHistogram registers the node startup duration
+// histogram vec with the given buckets. The histogram is shared by the cloud
+// provider observers so the already registered histogram is returned if more
+// than one is enabled.
+func registerStartUpDurationHistogram(buckets []float64) (*prometheus.HistogramVec, error) {
+	if len(buckets) == 0 {
+		buckets = DefaultNodeStartUpBuckets
+	}
+
+	startUpDurationSeconds := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:      "startup_duration_seconds",
+			Help:      "The node startup latencies in seconds.",
+			Subsystem: "node",
+			Buckets:   buckets,
 		},
+		startUpLabels,
 	)
 
 	err := prometheus.Register(startUpDurationSeconds)
 	if err != nil {
+		if registered, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			if existing, ok := registered.ExistingCollector.(*prometheus.HistogramVec); ok {
+				return existing, nil
+			}
+		}
 		return nil, err
 	}
+	return startUpDurationSeconds, nil
+}
 
-	return &ASGNodeStartUpObserver{
-		ec2Client:              ec2.New(sess),
-		startUpDurationSeconds: startUpDurationSeconds,
-	}, nil
+// run processes the queued observations in batches such that the ec2
+// instances of a batch are described together.
+func (o *ASGNodeStartUpObserver) run() {
+	for startUp := range o.queue {
+		batch := []NodeStartUp{startUp}
+		timeout := time.After(o.batchInterval)
+	collect:
+		for len(batch) < maxDescribeInstanceIDs {
+			select {
+			case startUp := <-o.queue:
+				batch = append(batch, startUp)
+			case <-timeout:
+				break collect
+			}
+		}
+		o.observeBatch(batch)
+	}
 }
 
-// ObserveNode observes the node startup time duration based on the launch
-// time of the underlying ec2 instance.
-// The observation is executed in a go routine to not block the caller.
-func (o *ASGNodeStartUpObserver) ObserveNode(node v1.Node) {
-	go func() {
-		now := time.Now().UTC()
+// ObserveNode queues the observation of the node startup. The observations
+// are processed in batches to not block the caller and to limit the number
+// of ec2 API calls. If the queue is full the observation is dropped.
+func (o *ASGNodeStartUpObserver) ObserveNode(startUp NodeStartUp) {
+	if o.observed.observed(&startUp.Node) {
+		log.Infof("Ignoring node %s already observed", startUp.Node.Name)
+		return
+	}
+
+	select {
+	case o.queue <- startUp:
+	default:
+		log.Warnf("Dropping startup observation of node %s, queue is full", startUp.Node.Name)
+	}
+}
+
+// GarbageCollect forgets the observed nodes and cached instances of nodes
+// which no longer exist.
+func (o *ASGNodeStartUpObserver) GarbageCollect(nodes []v1.Node) {
+	o.observed.GarbageCollect(nodes)
+
+	existing := make(map[string]struct{}, len(nodes))
+	for _, node := range nodes {
+		instanceID, err := providerid.EC2InstanceID(node.Spec.ProviderID)
+		if err == nil {
+			existing[instanceID] = struct{}{}
+		}
+	}
+
+	o.instancesMutex.Lock()
+	defer o.instancesMutex.Unlock()
+	for instanceID := range o.instances {
+		if _, ok := existing[instanceID]; !ok {
+			delete(o.instances, instanceID)
+		}
+	}
+}
 
-		if _, ok := o.nodesObserved.Load(node.Name); ok {
-			log.Infof("Ignoring node %s already observed", node.Name)
-			return
+// observeBatch observes the node startup time duration based on the launch
+// time of the underlying ec2 instance as well as the duration from launch
+// until node registration for a batch of nodes.
+func (o *ASGNodeStartUpObserver) observeBatch(batch []NodeStartUp) {
+	instanceIDs := make(map[string]string, len(batch))
+	var describe []string
+	for _, startUp := range batch {
+		instanceID, err := providerid.EC2InstanceID(startUp.Node.Spec.ProviderID)
+		if err != nil {
+			log.Errorf("Failed to get instanceID for node %s: %v", startUp.Node.Name, err)
+			continue
+		}
+		if _, ok := o.cachedInstance(instanceID); !ok && !containsString(describe, instanceID) {
+			describe = append(describe, instanceID)
 		}
+		instanceIDs[startUp.Node.Name] = instanceID
+	}
 
-		launchTime, err := o.nodeLaunchTime(node.Spec.ProviderID)
+	if len(describe) > 0 {
+		err := o.describeInstances(describe)
 		if err != nil {
-			log.Errorf("Failed to get node launch time: %v", err)
-			return
+			log.Errorf("Failed to describe instances: %v", err)
 		}
+	}
 
-		o.startUpDurationSeconds.Observe(now.Sub(launchTime).Seconds())
+	for _, startUp := range batch {
+		node := startUp.Node
+		now := startUp.Ready.UTC()
+
+		// the node could have been queued more than once.
+		if o.observed.observed(&node) {
+			continue
+		}
+
+		instanceID, ok := instanceIDs[node.Name]
+		if !ok {
+			continue
+		}
+
+		instance, ok := o.cachedInstance(instanceID)
+		if !ok {
+			log.Errorf("Failed to get node launch time: instance %s of node %s not found", instanceID, node.Name)
+			continue
+		}
+
+		launchTime := aws.TimeValue(instance.LaunchTime)
+		labels := o.series.labels(startUpLabelValues(instance, &node))
+		o.startUpDurationSeconds.WithLabelValues(labels...).Observe(now.Sub(launchTime).Seconds())
+		if !node.CreationTimestamp.IsZero() {
+			startUpPhaseDuration.WithLabelValues(phaseLaunchToRegistration).Observe(node.CreationTimestamp.Sub(launchTime).Seconds())
+		}
 
 		// record that node was observed
-		o.nodesObserved.Store(node.Name, nil)
-	}()
+		o.observed.markObserved(&node, now)
+	}
 }
 
-// nodeLaunchTime get the startup time of the underlying ec2 instance.
-func (o *ASGNodeStartUpObserver) nodeLaunchTime(providerID string) (time.Time, error) {
-	instanceID, err := instanceIDFromProviderID(providerID)
-	if err != nil {
-		return time.Time{}, fmt.Errorf("Failed to get instanceID for node: %v", err)
+// cachedInstance returns the cached ec2 instance.
+func (o *ASGNodeStartUpObserver) cachedInstance(instanceID string) (*ec2.Instance, bool) {
+	o.instancesMutex.Lock()
+	defer o.instancesMutex.Unlock()
+	instance, ok := o.instances[instanceID]
+	return instance, ok
+}
+
+// describeInstances describes the ec2 instances in batches of up to
+// maxDescribeInstanceIDs and caches them by their instance ID. A batch failing
+// doesn't stop the following batches from being described.
+func (o *ASGNodeStartUpObserver) describeInstances(instanceIDs []string) error {
+	var lastErr error
+	for len(instanceIDs) > 0 {
+		n := len(instanceIDs)
+		if n > maxDescribeInstanceIDs {
+			n = maxDescribeInstanceIDs
+		}
+
+		err := o.describeInstanceBatch(instanceIDs[:n])
+		if err != nil {
+			lastErr = err
+		}
+		instanceIDs = instanceIDs[n:]
 	}
 
+	return lastErr
+}
+
+// describeInstanceBatch describes a batch of ec2 instances and caches them by
+// their instance ID. Throttled and transient failures are retried with
+// exponential backoff. If some of the instances don't exist, e.g. because
+// they were already terminated, the remaining instances are described again.
+func (o *ASGNodeStartUpObserver) describeInstanceBatch(instanceIDs []string) error {
 	params := &ec2.DescribeInstancesInput{
-		InstanceIds: []*string{aws.String(instanceID)},
+		InstanceIds: aws.StringSlice(instanceIDs),
 	}
 
-	resp, err := o.ec2Client.DescribeInstances(params)
-	if err != nil {
-		return time.Time{}, fmt.Errorf("Failed to describe instance: %v", err)
+	for {
+		var resp *ec2.DescribeInstancesOutput
+		describe := func() error {
+			var err error
+			resp, err = o.ec2Client.DescribeInstances(params)
+			if err != nil {
+				if isThrottlingError(err) || isTransientError(err) {
+					return err
+				}
+				return backoff.Permanent(err)
+			}
+			return nil
+		}
+
+		err := backoff.Retry(describe, o.newBackOff())
+		if err != nil {
+			if isInstanceNotFoundError(err) {
+				return o.describeFoundInstances(instanceIDs, err)
+			}
+			return err
+		}
+
+		o.instancesMutex.Lock()
+		for _, reservation := range resp.Reservations {
+			for _, instance := range reservation.Instances {
+				o.instances[aws.StringValue(instance.InstanceId)] = instance
+			}
+		}
+		o.instancesMutex.Unlock()
+
+		if aws.StringValue(resp.NextToken) == "" {
+			return nil
+		}
+		params.NextToken = resp.NextToken
+	}
+}
+
+// describeFoundInstances describes the instances except the ones reported as
+// not found by the error. If the error doesn't name any of the instances, the
+// instances are split in half and each half is described on its own.
+func (o *ASGNodeStartUpObserver) describeFoundInstances(instanceIDs []string, err error) error {
+	notFound := make(map[string]struct{})
+	for _, instanceID := range instanceIDPattern.FindAllString(err.Error(), -1) {
+		notFound[instanceID] = struct{}{}
+	}
+
+	remaining := make([]string, 0, len(instanceIDs))
+	for _, instanceID := range instanceIDs {
+		if _, ok := notFound[instanceID]; ok {
+			log.Warnf("Instance %s not found", instanceID)
+			continue
+		}
+		remaining = append(remaining, instanceID)
+	}
+
+	if len(remaining) == 0 {
+		return nil
+	}
+
+	if len(remaining) < len(instanceIDs) {
+		return o.describeInstanceBatch(remaining)
+	}
+
+	if len(instanceIDs) == 1 {
+		log.Warnf("Instance %s not found: %v", instanceIDs[0], err)
+		return nil
+	}
+
+	half := len(instanceIDs) / 2
+	err = o.describeInstanceBatch(instanceIDs[:half])
+	if err2 := o.describeInstanceBatch(instanceIDs[half:]); err2 != nil {
+		err = err2
+	}
+	return err
+}
+
+// isThrottlingError returns true if the error is caused by exceeding the
+// AWS API rate limit.
+func isThrottlingError(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		switch awsErr.Code() {
+		case "RequestLimitExceeded", "Throttling", "ThrottlingException":
+			return true
+		}
+	}
+	return false
+}
+
+// isTransientError returns true if the error is caused by a server side
+// failure or by failing to send the request, which might succeed when retried.
+func isTransientError(err error) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() >= 500 {
+		return true
+	}
+
+	if awsErr, ok := err.(awserr.Error); ok {
+		switch awsErr.Code() {
+		case "RequestError", "InternalError", "Unavailable", "ServiceUnavailable":
+			return true
+		}
+	}
+	return false
+}
+
+// isInstanceNotFoundError returns true if the error is caused by describing
+// instances which don't exist.
+func isInstanceNotFoundError(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code() == "InvalidInstanceID.NotFound"
+	}
+	return false
+}
+
+// startUpLabelValues returns the instance type, availability zone, node group
+// and lifecycle of the node. The values are taken from the ec2 instance and
+// fall back to the node labels.
+func startUpLabelValues(instance *ec2.Instance, node *v1.Node) []string {
+	instanceType := aws.StringValue(instance.InstanceType)
+	if instanceType == "" {
+		instanceType = nodeLabel(node, instanceTypeNodeLabels)
+	}
+
+	var zone string
+	if instance.Placement != nil {
+		zone = aws.StringValue(instance.Placement.AvailabilityZone)
+	}
+	if zone == "" {
+		zone = nodeLabel(node, zoneNodeLabels)
+	}
+
+	var nodeGroup string
+	for _, tag := range instance.Tags {
+		if aws.StringValue(tag.Key) == asgNameTag {
+			nodeGroup = aws.StringValue(tag.Value)
+		}
+	}
+	if nodeGroup == "" {
+		nodeGroup = nodeLabel(node, nodeGroupNodeLabels)
 	}
 
-	if len(resp.Reservations) != 1 {
-		return time.Time{}, fmt.Errorf("Expected one reservation, got %d", len(resp.Reservations))
+	lifecycle := aws.StringValue(instance.InstanceLifecycle)
+	if lifecycle == "" {
+		lifecycle = strings.ToLower(nodeLabel(node, lifecycleNodeLabels))
+	}
+	switch lifecycle {
+	case lifecycleSpot:
+	case "", "normal", "ondemand", "on_demand", lifecycleOnDemand:
+		lifecycle = lifecycleOnDemand
 	}
 
-	if len(resp.Reservations[0].Instances) != 1 {
-		return time.Time{}, fmt.Errorf("Expected one instance, got %d", len(resp.Reservations[0].Instances))
+	return []string{instanceType, zone, nodeGroup, lifecycle}
+}
+
+// nodeLabel returns the value of the first of the labels set on the node.
+func nodeLabel(node *v1.Node, labels []string) string {
+	for _, label := range labels {
+		if value, ok := node.Labels[label]; ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// seriesGuard limits the number of label combinations of a metric vec to
+// bound its cardinality.
+type seriesGuard struct {
+	sync.Mutex
+	maxSeries int
+	seen      map[string]struct{}
+}
+
+// newSeriesGuard initializes a new seriesGuard allowing up to maxSeries
+// label combinations. A maxSeries of 0 or less means no limit.
+func newSeriesGuard(maxSeries int) *seriesGuard {
+	return &seriesGuard{
+		maxSeries: maxSeries,
+		seen:      make(map[string]struct{}),
+	}
+}
+
+// labels returns the label values if the combination was seen before or the
+// limit is not reached yet. Otherwise all values are replaced by "other".
+func (g *seriesGuard) labels(values []string) []string {
+	g.Lock()
+	defer g.Unlock()
+
+	key := strings.Join(values, "\x00")
+	if _, ok := g.seen[key]; ok || g.maxSeries <= 0 {
+		return values
+	}
+
+	if len(g.seen) >= g.maxSeries {
+		other := make([]string, len(values))
+		for i := range other {
+			other[i] = otherLabelValue
+		}
+		return other
 	}
 
-	return aws.TimeValue(resp.Reservations[0].Instances[0].LaunchTime), nil
+	g.seen[key] = struct{}{}
+	return values
 }
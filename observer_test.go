@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/cenkalti/backoff"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStartUpLabelValues(t *testing.T) {
+	for _, tc := range []struct {
+		msg      string
+		instance *ec2.Instance
+		labels   map[string]string
+		expected []string
+	}{
+		{
+			msg: "labels should be derived from the instance",
+			instance: &ec2.Instance{
+				InstanceType:      aws.String("m5.large"),
+				Placement:         &ec2.Placement{AvailabilityZone: aws.String("eu-central-1a")},
+				InstanceLifecycle: aws.String("spot"),
+				Tags: []*ec2.Tag{
+					{Key: aws.String("aws:autoscaling:groupName"), Value: aws.String("workers")},
+				},
+			},
+			expected: []string{"m5.large", "eu-central-1a", "workers", "spot"},
+		},
+		{
+			msg:      "labels should fall back to node labels",
+			instance: &ec2.Instance{},
+			labels: map[string]string{
+				"beta.kubernetes.io/instance-type":       "c5.xlarge",
+				"failure-domain.beta.kubernetes.io/zone": "eu-central-1b",
+				"eks.amazonaws.com/nodegroup":            "default",
+				"eks.amazonaws.com/capacityType":         "ON_DEMAND",
+			},
+			expected: []string{"c5.xlarge", "eu-central-1b", "default", "on-demand"},
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Labels: tc.labels}}
+			values := startUpLabelValues(tc.instance, node)
+			if !reflect.DeepEqual(values, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, values)
+			}
+		})
+	}
+}
+
+func TestSeriesGuard(t *testing.T) {
+	guard := newSeriesGuard(2)
+	for _, values := range [][]string{{"a"}, {"b"}, {"a"}} {
+		labels := guard.labels(values)
+		if !reflect.DeepEqual(labels, values) {
+			t.Errorf("expected %v, got %v", values, labels)
+		}
+	}
+
+	labels := guard.labels([]string{"c"})
+	if !reflect.DeepEqual(labels, []string{otherLabelValue}) {
+		t.Errorf("expected labels to be replaced when exceeding the limit, got %v", labels)
+	}
+}
+
+type mockEC2Client struct {
+	ec2iface.EC2API
+	instances map[string]*ec2.Instance
+	throttle  int
+	failures  int
+	// notFound fails requests including instances which don't exist.
+	notFound bool
+	// unnamed leaves out the instance IDs from not found errors.
+	unnamed   bool
+	calls     int
+	described []string
+}
+
+func (c *mockEC2Client) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	c.calls++
+	if c.throttle > 0 {
+		c.throttle--
+		return nil, awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil)
+	}
+
+	if c.failures > 0 {
+		c.failures--
+		return nil, awserr.NewRequestFailure(awserr.New("InternalError", "An internal error has occurred.", nil), 500, "")
+	}
+
+	if c.notFound {
+		var missing []string
+		for _, id := range aws.StringValueSlice(input.InstanceIds) {
+			if _, ok := c.instances[id]; !ok {
+				missing = append(missing, id)
+			}
+		}
+
+		if len(missing) > 0 {
+			message := fmt.Sprintf("The instance IDs '%s' do not exist", strings.Join(missing, ", "))
+			if c.unnamed {
+				message = "The instance ID does not exist"
+			}
+			return nil, awserr.New("InvalidInstanceID.NotFound", message, nil)
+		}
+	}
+
+	var instances []*ec2.Instance
+	for _, id := range aws.StringValueSlice(input.InstanceIds) {
+		c.described = append(c.described, id)
+		if instance, ok := c.instances[id]; ok {
+			instances = append(instances, instance)
+		}
+	}
+
+	return &ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{{Instances: instances}},
+	}, nil
+}
+
+func TestASGNodeStartUpObserver(t *testing.T) {
+	now := time.Now().UTC()
+	node := func(name string) v1.Node {
+		return v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				CreationTimestamp: metav1.NewTime(now.Add(-time.Minute)),
+			},
+			Spec: v1.NodeSpec{
+				ProviderID: "aws:///eu-central-1a/i-" + name,
+			},
+		}
+	}
+
+	ec2Client := &mockEC2Client{
+		instances: map[string]*ec2.Instance{
+			"i-a": {InstanceId: aws.String("i-a"), LaunchTime: aws.Time(now.Add(-2 * time.Minute))},
+			"i-b": {InstanceId: aws.String("i-b"), LaunchTime: aws.Time(now.Add(-3 * time.Minute))},
+		},
+		throttle: 1,
+	}
+
+	a, b, c := node("a"), node("b"), node("c")
+	observer := newASGNodeStartUpObserver(
+		ec2Client,
+		setupMockKubernetes(t, &a, nil),
+		prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "startup"}, startUpLabels),
+		10,
+	)
+	observer.newBackOff = func() backoff.BackOff {
+		return &backoff.ZeroBackOff{}
+	}
+
+	observer.observeBatch([]NodeStartUp{
+		{Node: a, Ready: now},
+		{Node: b, Ready: now},
+		{Node: c, Ready: now},
+		{Node: a, Ready: now},
+	})
+
+	// one throttled call and one call for all instances.
+	if ec2Client.calls != 2 {
+		t.Errorf("expected instances to be described in one retried call, got %d calls", ec2Client.calls)
+	}
+
+	if !observer.observed.observed(&a) || !observer.observed.observed(&b) || observer.observed.observed(&c) {
+		t.Error("expected nodes a and b to be observed")
+	}
+
+	// cached instances should not be described again.
+	ec2Client.described = nil
+	observer.observed.GarbageCollect(nil)
+	a.Annotations = nil
+	observer.observeBatch([]NodeStartUp{{Node: a, Ready: now}, {Node: c, Ready: now}})
+	if !reflect.DeepEqual(ec2Client.described, []string{"i-c"}) {
+		t.Errorf("expected only uncached instance i-c to be described, got %v", ec2Client.described)
+	}
+
+	// instances of deleted nodes should be removed from the cache.
+	observer.GarbageCollect([]v1.Node{b})
+	if _, ok := observer.cachedInstance("i-a"); ok {
+		t.Error("expected instance of deleted node to be removed from the cache")
+	}
+
+	if _, ok := observer.cachedInstance("i-b"); !ok {
+		t.Error("expected instance of existing node to be cached")
+	}
+}
+
+func TestDescribeInstances(t *testing.T) {
+	now := time.Now().UTC()
+	instances := map[string]*ec2.Instance{
+		"i-a": {InstanceId: aws.String("i-a"), LaunchTime: aws.Time(now)},
+		"i-b": {InstanceId: aws.String("i-b"), LaunchTime: aws.Time(now)},
+		"i-d": {InstanceId: aws.String("i-d"), LaunchTime: aws.Time(now)},
+	}
+
+	for _, tc := range []struct {
+		msg       string
+		ec2Client *mockEC2Client
+		success   bool
+	}{
+		{
+			msg:       "transient failures should be retried",
+			ec2Client: &mockEC2Client{instances: instances, failures: 2},
+			success:   true,
+		},
+		{
+			msg:       "instances named as not found should be left out",
+			ec2Client: &mockEC2Client{instances: instances, notFound: true},
+			success:   true,
+		},
+		{
+			msg:       "instances not found should be bisected if not named",
+			ec2Client: &mockEC2Client{instances: instances, notFound: true, unnamed: true},
+			success:   true,
+		},
+		{
+			msg:       "failures exceeding the backoff should fail",
+			ec2Client: &mockEC2Client{instances: instances, failures: 10},
+			success:   false,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			observer := newASGNodeStartUpObserver(tc.ec2Client, nil, nil, 10)
+			observer.newBackOff = func() backoff.BackOff {
+				return backoff.WithMaxRetries(&backoff.ZeroBackOff{}, 3)
+			}
+
+			err := observer.describeInstances([]string{"i-a", "i-b", "i-c", "i-d"})
+			if err != nil && tc.success {
+				t.Errorf("should not fail: %s", err)
+			}
+
+			if err == nil && !tc.success {
+				t.Error("expected failure")
+			}
+
+			if !tc.success {
+				return
+			}
+
+			for _, instanceID := range []string{"i-a", "i-b", "i-d"} {
+				if _, ok := observer.cachedInstance(instanceID); !ok {
+					t.Errorf("expected instance %s to be described", instanceID)
+				}
+			}
+		})
+	}
+}
+
+func TestASGNodeStartUpObserverQueue(t *testing.T) {
+	observer := newASGNodeStartUpObserver(&mockEC2Client{}, nil, nil, 10)
+	startUp := NodeStartUp{Node: v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "foo"}}}
+
+	// observations should be dropped when the queue is full.
+	for i := 0; i < observerQueueSize+1; i++ {
+		observer.ObserveNode(startUp)
+	}
+
+	if len(observer.queue) != observerQueueSize {
+		t.Errorf("expected %d queued observations, got %d", observerQueueSize, len(observer.queue))
+	}
+}
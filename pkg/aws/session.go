@@ -1,14 +1,72 @@
 package aws
 
 import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 )
 
-// Session sets up an AWS session with the region automatically detected from
-// the environment or the ec2 metadata service if running on ec2.
-func Session(config *aws.Config) (*session.Session, error) {
+const (
+	// DefaultRoleSessionName is the default session name used when assuming
+	// a role.
+	DefaultRoleSessionName = "kube-node-ready-controller"
+	// metadataTimeout is the timeout of requests to the ec2 metadata
+	// service such that the session setup doesn't hang off ec2.
+	metadataTimeout = 5 * time.Second
+	// webIdentityTokenFileEnv and roleARNEnv are the environment variables
+	// set for IAM roles for service accounts (IRSA).
+	webIdentityTokenFileEnv = "AWS_WEB_IDENTITY_TOKEN_FILE"
+	roleARNEnv              = "AWS_ROLE_ARN"
+)
+
+// SessionOptions configures the AWS session.
+type SessionOptions struct {
+	// Region is the AWS region. If empty the region is detected from the
+	// environment or the ec2 metadata service.
+	Region string
+	// DisableMetadataRegion disables the fallback to the ec2 metadata
+	// service if no region is configured.
+	DisableMetadataRegion bool
+	// RoleARN is the ARN of a role to assume.
+	RoleARN string
+	// ExternalID is the external ID used when assuming RoleARN.
+	ExternalID string
+	// RoleSessionName is the session name used when assuming a role.
+	// Defaults to DefaultRoleSessionName.
+	RoleSessionName string
+	// WebIdentityTokenFile is the path to a web identity token used to
+	// assume WebIdentityRoleARN. Defaults to AWS_WEB_IDENTITY_TOKEN_FILE.
+	WebIdentityTokenFile string
+	// WebIdentityRoleARN is the ARN of the role assumed with the web
+	// identity token. Defaults to AWS_ROLE_ARN.
+	WebIdentityRoleARN string
+	// Endpoints are custom endpoint URLs keyed by service ID, e.g.
+	// autoscaling or ec2.
+	Endpoints map[string]string
+}
+
+// NewSession sets up an AWS session configured by the options. Credentials
+// are taken from the web identity token if configured and otherwise from the
+// default credential chain. If a role ARN is set the role is assumed with
+// those credentials.
+func NewSession(config *aws.Config, opts SessionOptions) (*session.Session, error) {
+	config = config.Copy()
+	if opts.Region != "" {
+		config.Region = aws.String(opts.Region)
+	}
+
+	if len(opts.Endpoints) > 0 {
+		config.EndpointResolver = endpointResolver(opts.Endpoints)
+	}
+
 	sess, err := session.NewSessionWithOptions(session.Options{
 		Config:            *config,
 		SharedConfigState: session.SharedConfigEnable,
@@ -18,14 +76,83 @@ func Session(config *aws.Config) (*session.Session, error) {
 	}
 
 	if aws.StringValue(sess.Config.Region) == "" {
-		// try to get region from metadata service
-		metadata := ec2metadata.New(sess)
-		region, err := metadata.Region()
+		region, err := metadataRegion(sess, opts.DisableMetadataRegion)
 		if err != nil {
 			return nil, err
 		}
 		sess.Config.Region = aws.String(region)
 	}
 
+	roleSessionName := opts.RoleSessionName
+	if roleSessionName == "" {
+		roleSessionName = DefaultRoleSessionName
+	}
+
+	tokenFile := opts.WebIdentityTokenFile
+	if tokenFile == "" {
+		tokenFile = os.Getenv(webIdentityTokenFileEnv)
+	}
+
+	webIdentityRoleARN := opts.WebIdentityRoleARN
+	if webIdentityRoleARN == "" {
+		webIdentityRoleARN = os.Getenv(roleARNEnv)
+	}
+
+	if tokenFile != "" {
+		if webIdentityRoleARN == "" {
+			return nil, fmt.Errorf("web identity token file %s configured without role ARN, set %s", tokenFile, roleARNEnv)
+		}
+		creds := credentials.NewCredentials(NewWebIdentityRoleProvider(sess, webIdentityRoleARN, roleSessionName, tokenFile))
+		sess = sess.Copy(&aws.Config{Credentials: creds})
+	}
+
+	if opts.RoleARN != "" {
+		creds := stscreds.NewCredentials(sess, opts.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = roleSessionName
+			if opts.ExternalID != "" {
+				p.ExternalID = aws.String(opts.ExternalID)
+			}
+		})
+		sess = sess.Copy(&aws.Config{Credentials: creds})
+	}
+
 	return sess, nil
 }
+
+// metadataRegion gets the region from the ec2 metadata service. A clear
+// error is returned if the fallback is disabled or the metadata service is
+// unreachable, e.g. when not running on ec2.
+func metadataRegion(sess *session.Session, disabled bool) (string, error) {
+	if disabled {
+		return "", fmt.Errorf("no AWS region configured and the ec2 metadata fallback is disabled, set the region or AWS_REGION")
+	}
+
+	metadata := ec2metadata.New(sess, &aws.Config{
+		HTTPClient: &http.Client{Timeout: metadataTimeout},
+		MaxRetries: aws.Int(2),
+	})
+	if !metadata.Available() {
+		return "", fmt.Errorf("no AWS region configured and the ec2 metadata service is unreachable, set the region or AWS_REGION")
+	}
+
+	region, err := metadata.Region()
+	if err != nil {
+		return "", fmt.Errorf("failed to get region from the ec2 metadata service: %v", err)
+	}
+	return region, nil
+}
+
+// endpointResolver returns an endpoint resolver resolving the services with
+// a custom endpoint to that endpoint and all other services to the default
+// endpoint.
+func endpointResolver(custom map[string]string) endpoints.Resolver {
+	return endpoints.ResolverFunc(func(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		if url, ok := custom[service]; ok {
+			return endpoints.ResolvedEndpoint{
+				URL:           url,
+				SigningRegion: region,
+			}, nil
+		}
+		return endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+	})
+}
@@ -0,0 +1,113 @@
+package aws
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+func TestEndpointResolver(t *testing.T) {
+	resolver := endpointResolver(map[string]string{endpoints.AutoscalingServiceID: "http://localhost:5000"})
+
+	endpoint, err := resolver.EndpointFor(endpoints.AutoscalingServiceID, "eu-central-1")
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	if endpoint.URL != "http://localhost:5000" || endpoint.SigningRegion != "eu-central-1" {
+		t.Errorf("expected custom endpoint, got %v", endpoint)
+	}
+
+	endpoint, err = resolver.EndpointFor(endpoints.Ec2ServiceID, "eu-central-1")
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	if endpoint.URL != "https://ec2.eu-central-1.amazonaws.com" {
+		t.Errorf("expected default endpoint, got %v", endpoint)
+	}
+}
+
+func TestNewSessionRegion(t *testing.T) {
+	os.Unsetenv("AWS_REGION")
+	os.Unsetenv("AWS_DEFAULT_REGION")
+
+	sess, err := NewSession(aws.NewConfig(), SessionOptions{Region: "eu-central-1", DisableMetadataRegion: true})
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	if aws.StringValue(sess.Config.Region) != "eu-central-1" {
+		t.Errorf("expected region eu-central-1, got %s", aws.StringValue(sess.Config.Region))
+	}
+
+	_, err = NewSession(aws.NewConfig(), SessionOptions{DisableMetadataRegion: true})
+	if err == nil {
+		t.Error("expected failure without region")
+	}
+}
+
+type mockSTSClient struct {
+	stsiface.STSAPI
+	input *sts.AssumeRoleWithWebIdentityInput
+}
+
+func (c *mockSTSClient) AssumeRoleWithWebIdentity(input *sts.AssumeRoleWithWebIdentityInput) (*sts.AssumeRoleWithWebIdentityOutput, error) {
+	c.input = input
+	return &sts.AssumeRoleWithWebIdentityOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String("id"),
+			SecretAccessKey: aws.String("secret"),
+			SessionToken:    aws.String("token"),
+			Expiration:      aws.Time(time.Now().Add(time.Hour)),
+		},
+	}, nil
+}
+
+func TestWebIdentityRoleProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web-identity")
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "token")
+	err = ioutil.WriteFile(tokenFile, []byte("jwt"), 0600)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	client := &mockSTSClient{}
+	provider := &WebIdentityRoleProvider{
+		client:          client,
+		roleARN:         "arn:aws:iam::123456789012:role/test",
+		roleSessionName: DefaultRoleSessionName,
+		tokenFile:       tokenFile,
+	}
+
+	value, err := provider.Retrieve()
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	if value.AccessKeyID != "id" || aws.StringValue(client.input.WebIdentityToken) != "jwt" {
+		t.Errorf("unexpected credentials %v for input %v", value, client.input)
+	}
+
+	if provider.IsExpired() {
+		t.Error("expected credentials to not be expired")
+	}
+
+	provider.tokenFile = filepath.Join(dir, "missing")
+	_, err = provider.Retrieve()
+	if err == nil {
+		t.Error("expected failure")
+	}
+}
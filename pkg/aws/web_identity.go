@@ -0,0 +1,69 @@
+package aws
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+const (
+	// WebIdentityProviderName is the name of the web identity credentials
+	// provider.
+	WebIdentityProviderName = "WebIdentityRoleProvider"
+	// webIdentityExpiryWindow is the time before the credentials expire at
+	// which they are refreshed.
+	webIdentityExpiryWindow = 5 * time.Minute
+)
+
+// WebIdentityRoleProvider retrieves credentials by assuming a role with a
+// web identity token read from a file, as used by IAM roles for service
+// accounts. The token is read on every retrieval as it's rotated.
+type WebIdentityRoleProvider struct {
+	credentials.Expiry
+	client          stsiface.STSAPI
+	roleARN         string
+	roleSessionName string
+	tokenFile       string
+}
+
+// NewWebIdentityRoleProvider creates a new WebIdentityRoleProvider.
+func NewWebIdentityRoleProvider(c client.ConfigProvider, roleARN, roleSessionName, tokenFile string) *WebIdentityRoleProvider {
+	return &WebIdentityRoleProvider{
+		client:          sts.New(c),
+		roleARN:         roleARN,
+		roleSessionName: roleSessionName,
+		tokenFile:       tokenFile,
+	}
+}
+
+// Retrieve assumes the role with the web identity token.
+func (p *WebIdentityRoleProvider) Retrieve() (credentials.Value, error) {
+	token, err := ioutil.ReadFile(p.tokenFile)
+	if err != nil {
+		return credentials.Value{ProviderName: WebIdentityProviderName}, fmt.Errorf("failed to read web identity token: %v", err)
+	}
+
+	resp, err := p.client.AssumeRoleWithWebIdentity(&sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(p.roleARN),
+		RoleSessionName:  aws.String(p.roleSessionName),
+		WebIdentityToken: aws.String(string(token)),
+	})
+	if err != nil {
+		return credentials.Value{ProviderName: WebIdentityProviderName}, fmt.Errorf("failed to assume role %s with web identity: %v", p.roleARN, err)
+	}
+
+	p.SetExpiration(aws.TimeValue(resp.Credentials.Expiration), webIdentityExpiryWindow)
+
+	return credentials.Value{
+		AccessKeyID:     aws.StringValue(resp.Credentials.AccessKeyId),
+		SecretAccessKey: aws.StringValue(resp.Credentials.SecretAccessKey),
+		SessionToken:    aws.StringValue(resp.Credentials.SessionToken),
+		ProviderName:    WebIdentityProviderName,
+	}, nil
+}
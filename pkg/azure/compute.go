@@ -0,0 +1,258 @@
+// Package azure implements a minimal client for the parts of the Azure
+// Resource Manager API used by the controller.
+package azure
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mikkeloscar/kube-node-ready-controller/pkg/providerid"
+)
+
+const (
+	// DefaultEndpoint is the endpoint of the Azure Resource Manager API.
+	DefaultEndpoint = "https://management.azure.com/"
+	// DefaultMetadataEndpoint is the endpoint of the instance metadata
+	// service available on Azure VMs.
+	DefaultMetadataEndpoint = "http://169.254.169.254/metadata/"
+	computeAPIVersion       = "2022-03-01"
+	tagsAPIVersion          = "2021-04-01"
+	metadataAPIVersion      = "2018-02-01"
+	// tokenExpiryDelta is subtracted from the token expiry to refresh tokens
+	// before they expire.
+	tokenExpiryDelta = time.Minute
+)
+
+// ComputeAPI describes the Resource Manager operations on virtual machines.
+type ComputeAPI interface {
+	GetVirtualMachine(resourceID string) (*VirtualMachine, error)
+	MergeTags(resourceID string, tags map[string]string) error
+}
+
+// VirtualMachine describes a virtual machine or a virtual machine scale set
+// instance.
+type VirtualMachine struct {
+	Name     string            `json:"name"`
+	Location string            `json:"location"`
+	Zones    []string          `json:"zones"`
+	Tags     map[string]string `json:"tags"`
+	SKU      struct {
+		Name string `json:"name"`
+	} `json:"sku"`
+	Properties struct {
+		TimeCreated     time.Time `json:"timeCreated"`
+		Priority        string    `json:"priority"`
+		HardwareProfile struct {
+			VMSize string `json:"vmSize"`
+		} `json:"hardwareProfile"`
+	} `json:"properties"`
+}
+
+// VMSize returns the size of the virtual machine. For scale set instances
+// the size is the SKU of the instance.
+func (vm *VirtualMachine) VMSize() string {
+	if vm.Properties.HardwareProfile.VMSize != "" {
+		return vm.Properties.HardwareProfile.VMSize
+	}
+	return vm.SKU.Name
+}
+
+// Zone returns the zone of the virtual machine in the format
+// <location>-<zone> used by the Kubernetes topology labels.
+func (vm *VirtualMachine) Zone() string {
+	if len(vm.Zones) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s-%s", strings.ToLower(vm.Location), vm.Zones[0])
+}
+
+// Spot returns true if the virtual machine is a spot or low priority
+// virtual machine.
+func (vm *VirtualMachine) Spot() bool {
+	switch strings.ToLower(vm.Properties.Priority) {
+	case "spot", "low":
+		return true
+	}
+	return false
+}
+
+// ResourceID returns the resource ID of the virtual machine or scale set
+// instance referenced by an azure provider ID.
+func ResourceID(instance *providerid.Instance) string {
+	resourceID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute", instance.SubscriptionID, instance.ResourceGroup)
+	if instance.ScaleSet != "" {
+		resourceID += "/virtualMachineScaleSets/" + instance.ScaleSet
+	}
+	return resourceID + "/virtualMachines/" + instance.ID
+}
+
+// Error is an error returned by the Resource Manager API.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("resource manager request failed with status %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Client is a client for the Resource Manager API authenticating with the
+// managed identity of the VM from the instance metadata service.
+type Client struct {
+	Endpoint         string
+	MetadataEndpoint string
+	// ClientID selects a user assigned managed identity. If empty the
+	// system assigned identity is used.
+	ClientID   string
+	HTTPClient *http.Client
+
+	tokenMutex  sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewClient creates a new Resource Manager API client using the default
+// endpoints.
+func NewClient() *Client {
+	return &Client{
+		Endpoint:         DefaultEndpoint,
+		MetadataEndpoint: DefaultMetadataEndpoint,
+		HTTPClient:       &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// GetVirtualMachine gets the virtual machine or scale set instance.
+func (c *Client) GetVirtualMachine(resourceID string) (*VirtualMachine, error) {
+	var vm VirtualMachine
+	err := c.do(http.MethodGet, resourceID, computeAPIVersion, nil, &vm)
+	if err != nil {
+		return nil, err
+	}
+	return &vm, nil
+}
+
+// MergeTags merges the tags into the existing tags of the resource. Scale set
+// instances don't support tags of their own.
+func (c *Client) MergeTags(resourceID string, tags map[string]string) error {
+	body := map[string]interface{}{
+		"operation": "Merge",
+		"properties": map[string]interface{}{
+			"tags": tags,
+		},
+	}
+	return c.do(http.MethodPatch, resourceID+"/providers/Microsoft.Resources/tags/default", tagsAPIVersion, body, nil)
+}
+
+// do sends an authenticated request to the Resource Manager API and decodes
+// the response into out if not nil.
+func (c *Client) do(method, path, apiVersion string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		err := json.NewEncoder(&body).Encode(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	endpoint := fmt.Sprintf("%s%s?api-version=%s", strings.TrimSuffix(c.Endpoint, "/"), path, apiVersion)
+	req, err := http.NewRequest(method, endpoint, &body)
+	if err != nil {
+		return err
+	}
+
+	token, err := c.accessToken()
+	if err != nil {
+		return fmt.Errorf("failed to get access token: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var errResp struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		apiErr := &Error{StatusCode: resp.StatusCode, Message: string(data)}
+		if json.Unmarshal(data, &errResp) == nil && errResp.Error.Message != "" {
+			apiErr.Code = errResp.Error.Code
+			apiErr.Message = errResp.Error.Message
+		}
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// accessToken returns an access token of the managed identity for the
+// Resource Manager API. The token is cached until shortly before it expires.
+func (c *Client) accessToken() (string, error) {
+	c.tokenMutex.Lock()
+	defer c.tokenMutex.Unlock()
+
+	if c.token != "" && time.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	params := url.Values{}
+	params.Set("api-version", metadataAPIVersion)
+	params.Set("resource", DefaultEndpoint)
+	if c.ClientID != "" {
+		params.Set("client_id", c.ClientID)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(c.MetadataEndpoint, "/")+"/identity/oauth2/token?"+params.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("instance metadata service returned status %d", resp.StatusCode)
+	}
+
+	// expires_in is returned as a string.
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   string `json:"expires_in"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return "", err
+	}
+
+	expiresIn, err := strconv.Atoi(token.ExpiresIn)
+	if err != nil {
+		return "", fmt.Errorf("invalid token expiry '%s': %v", token.ExpiresIn, err)
+	}
+
+	c.token = token.AccessToken
+	c.tokenExpiry = time.Now().Add(time.Duration(expiresIn)*time.Second - tokenExpiryDelta)
+	return c.token, nil
+}
@@ -0,0 +1,95 @@
+package azure
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mikkeloscar/kube-node-ready-controller/pkg/providerid"
+)
+
+func TestClient(t *testing.T) {
+	var tokenRequests int
+	var tags map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/metadata/identity/oauth2/token" {
+			if r.Header.Get("Metadata") != "true" || r.URL.Query().Get("resource") != DefaultEndpoint {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			tokenRequests++
+			w.Write([]byte(`{"access_token":"token","expires_in":"3600"}`))
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer token" || r.URL.Query().Get("api-version") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/arm/subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachineScaleSets/pool/virtualMachines/3":
+			w.Write([]byte(`{
+				"name": "pool_3",
+				"location": "WestEurope",
+				"zones": ["2"],
+				"sku": {"name": "Standard_D4s_v3"},
+				"properties": {"timeCreated": "2022-04-20T02:35:11.1234567+00:00"}
+			}`))
+		case "/arm/subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachines/vm-1/providers/Microsoft.Resources/tags/default":
+			if r.Method != http.MethodPatch {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			json.NewDecoder(r.Body).Decode(&tags)
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": "ResourceNotFound", "message": "not found"}}`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient()
+	client.Endpoint = server.URL + "/arm/"
+	client.MetadataEndpoint = server.URL + "/metadata/"
+
+	instance, err := providerid.Parse("azure:///subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachineScaleSets/pool/virtualMachines/3")
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	vm, err := client.GetVirtualMachine(ResourceID(instance))
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	if vm.VMSize() != "Standard_D4s_v3" || vm.Zone() != "westeurope-2" || vm.Spot() || vm.Properties.TimeCreated.IsZero() {
+		t.Errorf("unexpected virtual machine %#v", vm)
+	}
+
+	vmInstance, err := providerid.Parse("azure:///subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachines/vm-1")
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	err = client.MergeTags(ResourceID(vmInstance), map[string]string{"ready": "true"})
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	if tags["operation"] != "Merge" {
+		t.Errorf("expected tags to be merged, got %v", tags)
+	}
+
+	instance.ID = "4"
+	_, err = client.GetVirtualMachine(ResourceID(instance))
+	if apiErr, ok := err.(*Error); !ok || apiErr.StatusCode != http.StatusNotFound || apiErr.Code != "ResourceNotFound" {
+		t.Errorf("expected not found error, got %v", err)
+	}
+
+	if tokenRequests != 1 {
+		t.Errorf("expected token to be cached, got %d token requests", tokenRequests)
+	}
+}
@@ -0,0 +1,232 @@
+// Package gce implements a minimal client for the parts of the Google Compute
+// Engine API used by the controller.
+package gce
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultEndpoint is the endpoint of the compute API.
+	DefaultEndpoint = "https://compute.googleapis.com/compute/v1/"
+	// DefaultMetadataEndpoint is the endpoint of the metadata server
+	// available on GCE instances.
+	DefaultMetadataEndpoint = "http://metadata.google.internal/computeMetadata/v1/"
+	// createdByMetadataKey is the instance metadata item referencing the
+	// managed instance group which created the instance.
+	createdByMetadataKey = "created-by"
+	// tokenExpiryDelta is subtracted from the token expiry to refresh tokens
+	// before they expire.
+	tokenExpiryDelta = time.Minute
+)
+
+// ComputeAPI describes the compute API operations on instances.
+type ComputeAPI interface {
+	GetInstance(project, zone, name string) (*Instance, error)
+	SetInstanceLabels(project, zone, name string, labels map[string]string, fingerprint string) error
+}
+
+// Instance describes a compute instance.
+type Instance struct {
+	Name              string            `json:"name"`
+	Zone              string            `json:"zone"`
+	MachineType       string            `json:"machineType"`
+	CreationTimestamp time.Time         `json:"creationTimestamp"`
+	Labels            map[string]string `json:"labels"`
+	LabelFingerprint  string            `json:"labelFingerprint"`
+	Scheduling        struct {
+		Preemptible       bool   `json:"preemptible"`
+		ProvisioningModel string `json:"provisioningModel"`
+	} `json:"scheduling"`
+	Metadata struct {
+		Items []struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		} `json:"items"`
+	} `json:"metadata"`
+}
+
+// InstanceGroupManager returns the name of the managed instance group which
+// created the instance or an empty string if the instance is not part of a
+// managed instance group.
+func (i *Instance) InstanceGroupManager() string {
+	for _, item := range i.Metadata.Items {
+		if item.Key == createdByMetadataKey {
+			return lastSegment(item.Value)
+		}
+	}
+	return ""
+}
+
+// MachineTypeName returns the name of the machine type of the instance.
+func (i *Instance) MachineTypeName() string {
+	return lastSegment(i.MachineType)
+}
+
+// ZoneName returns the name of the zone of the instance.
+func (i *Instance) ZoneName() string {
+	return lastSegment(i.Zone)
+}
+
+// Spot returns true if the instance is a preemptible or spot instance.
+func (i *Instance) Spot() bool {
+	return i.Scheduling.Preemptible || i.Scheduling.ProvisioningModel == "SPOT"
+}
+
+// Error is an error returned by the compute API.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("compute API request failed with status %d: %s", e.StatusCode, e.Message)
+}
+
+// Client is a client for the compute API authenticating with the token of
+// the instance service account from the metadata server.
+type Client struct {
+	Endpoint         string
+	MetadataEndpoint string
+	HTTPClient       *http.Client
+
+	tokenMutex  sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewClient creates a new compute API client using the default endpoints.
+func NewClient() *Client {
+	return &Client{
+		Endpoint:         DefaultEndpoint,
+		MetadataEndpoint: DefaultMetadataEndpoint,
+		HTTPClient:       &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// GetInstance gets the instance in the project and zone.
+func (c *Client) GetInstance(project, zone, name string) (*Instance, error) {
+	var instance Instance
+	err := c.do(http.MethodGet, instancePath(project, zone, name), nil, &instance)
+	if err != nil {
+		return nil, err
+	}
+	return &instance, nil
+}
+
+// SetInstanceLabels sets the labels of the instance. The fingerprint must be
+// the label fingerprint of the instance the labels are based on.
+func (c *Client) SetInstanceLabels(project, zone, name string, labels map[string]string, fingerprint string) error {
+	body := map[string]interface{}{
+		"labels":           labels,
+		"labelFingerprint": fingerprint,
+	}
+	return c.do(http.MethodPost, instancePath(project, zone, name)+"/setLabels", body, nil)
+}
+
+// do sends an authenticated request to the compute API and decodes the
+// response into out if not nil.
+func (c *Client) do(method, path string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		err := json.NewEncoder(&body).Encode(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.Endpoint, "/")+path, &body)
+	if err != nil {
+		return err
+	}
+
+	token, err := c.accessToken()
+	if err != nil {
+		return fmt.Errorf("failed to get access token: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var errResp struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		message := string(data)
+		if json.Unmarshal(data, &errResp) == nil && errResp.Error.Message != "" {
+			message = errResp.Error.Message
+		}
+		return &Error{StatusCode: resp.StatusCode, Message: message}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// accessToken returns the access token of the default service account of
+// the instance. The token is cached until shortly before it expires.
+func (c *Client) accessToken() (string, error) {
+	c.tokenMutex.Lock()
+	defer c.tokenMutex.Unlock()
+
+	if c.token != "" && time.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(c.MetadataEndpoint, "/")+"/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server returned status %d", resp.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return "", err
+	}
+
+	c.token = token.AccessToken
+	c.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - tokenExpiryDelta)
+	return c.token, nil
+}
+
+// instancePath returns the API path of the instance.
+func instancePath(project, zone, name string) string {
+	return fmt.Sprintf("/projects/%s/zones/%s/instances/%s", url.PathEscape(project), url.PathEscape(zone), url.PathEscape(name))
+}
+
+// lastSegment returns the last segment of a resource URL.
+func lastSegment(resource string) string {
+	return resource[strings.LastIndex(resource, "/")+1:]
+}
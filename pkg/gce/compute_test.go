@@ -0,0 +1,82 @@
+package gce
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	var tokenRequests int
+	var setLabels map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata/instance/service-accounts/default/token":
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			tokenRequests++
+			w.Write([]byte(`{"access_token":"token","expires_in":3600}`))
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/compute/projects/project/zones/europe-west1-b/instances/node-1":
+			w.Write([]byte(`{
+				"name": "node-1",
+				"zone": "https://www.googleapis.com/compute/v1/projects/project/zones/europe-west1-b",
+				"machineType": "https://www.googleapis.com/compute/v1/projects/project/zones/europe-west1-b/machineTypes/n1-standard-4",
+				"creationTimestamp": "2018-04-20T02:35:11.123-07:00",
+				"labelFingerprint": "abc",
+				"scheduling": {"preemptible": true},
+				"metadata": {"items": [{"key": "created-by", "value": "projects/123/zones/europe-west1-b/instanceGroupManagers/workers"}]}
+			}`))
+		case "/compute/projects/project/zones/europe-west1-b/instances/node-1/setLabels":
+			json.NewDecoder(r.Body).Decode(&setLabels)
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "not found"}}`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient()
+	client.Endpoint = server.URL + "/compute/"
+	client.MetadataEndpoint = server.URL + "/metadata/"
+
+	instance, err := client.GetInstance("project", "europe-west1-b", "node-1")
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	if instance.MachineTypeName() != "n1-standard-4" || instance.ZoneName() != "europe-west1-b" ||
+		instance.InstanceGroupManager() != "workers" || !instance.Spot() || instance.CreationTimestamp.IsZero() {
+		t.Errorf("unexpected instance %#v", instance)
+	}
+
+	err = client.SetInstanceLabels("project", "europe-west1-b", "node-1", map[string]string{"ready": "true"}, instance.LabelFingerprint)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	if setLabels["labelFingerprint"] != "abc" {
+		t.Errorf("expected label fingerprint to be set, got %v", setLabels)
+	}
+
+	_, err = client.GetInstance("project", "europe-west1-b", "node-2")
+	if apiErr, ok := err.(*Error); !ok || apiErr.StatusCode != http.StatusNotFound || apiErr.Message != "not found" {
+		t.Errorf("expected not found error, got %v", err)
+	}
+
+	if tokenRequests != 1 {
+		t.Errorf("expected token to be cached, got %d token requests", tokenRequests)
+	}
+}
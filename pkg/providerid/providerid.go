@@ -0,0 +1,181 @@
+// Package providerid parses the provider IDs set by cloud providers in the
+// spec of Kubernetes nodes into references to the underlying instances.
+package providerid
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// AWS is the provider of nodes running on AWS.
+	AWS = "aws"
+	// GCE is the provider of nodes running on Google Compute Engine.
+	GCE = "gce"
+	// Azure is the provider of nodes running on Azure.
+	Azure = "azure"
+)
+
+// Instance is a reference to the cloud instance of a node.
+type Instance struct {
+	// ProviderID is the parsed provider ID.
+	ProviderID string
+	// Provider is the cloud provider, e.g. aws, gce or azure.
+	Provider string
+	// ID identifies the instance within the provider. For AWS it's the
+	// EC2 instance ID (or the Fargate node name), for GCE the instance
+	// name and for Azure the VM name or the instance ID within the scale
+	// set.
+	ID string
+	// Zone is the availability zone of the instance if part of the
+	// provider ID.
+	Zone string
+	// Project is the GCE project of the instance.
+	Project string
+	// SubscriptionID is the Azure subscription of the instance.
+	SubscriptionID string
+	// ResourceGroup is the Azure resource group of the instance.
+	ResourceGroup string
+	// ScaleSet is the Azure VM scale set of the instance if it's part of
+	// one.
+	ScaleSet string
+}
+
+// Parse parses a provider ID of the format <provider>://<provider specific>.
+// The following formats are supported:
+//
+//	aws:///<zone>/<instance-id>
+//	aws:///<instance-id>
+//	aws:///<zone>/fargate-<node-name>
+//	gce://<project>/<zone>/<instance-name>
+//	azure:///subscriptions/<id>/resourceGroups/<group>/providers/Microsoft.Compute/virtualMachines/<name>
+//	azure:///subscriptions/<id>/resourceGroups/<group>/providers/Microsoft.Compute/virtualMachineScaleSets/<scale-set>/virtualMachines/<instance-id>
+//
+// For other providers the ID is the last segment of the provider ID.
+func Parse(providerID string) (*Instance, error) {
+	split := strings.SplitN(providerID, "://", 2)
+	if len(split) != 2 || split[0] == "" {
+		return nil, fmt.Errorf("unexpected providerID format: %s", providerID)
+	}
+
+	instance := &Instance{
+		ProviderID: providerID,
+		Provider:   split[0],
+	}
+
+	var err error
+	switch instance.Provider {
+	case AWS:
+		err = instance.parseAWS(split[1])
+	case GCE:
+		err = instance.parseGCE(split[1])
+	case Azure:
+		err = instance.parseAzure(split[1])
+	default:
+		segments := pathSegments(split[1])
+		if len(segments) == 0 {
+			err = fmt.Errorf("missing instance ID")
+		} else {
+			instance.ID = segments[len(segments)-1]
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unexpected providerID format: %s: %v", providerID, err)
+	}
+
+	return instance, nil
+}
+
+// parseAWS parses [/][<zone>/]<instance-id>.
+func (i *Instance) parseAWS(path string) error {
+	segments := pathSegments(path)
+	switch len(segments) {
+	case 1:
+		i.ID = segments[0]
+	case 2:
+		i.Zone = segments[0]
+		i.ID = segments[1]
+	default:
+		return fmt.Errorf("expected [<zone>/]<instance-id>")
+	}
+	return nil
+}
+
+// parseGCE parses <project>/<zone>/<instance-name>.
+func (i *Instance) parseGCE(path string) error {
+	segments := pathSegments(path)
+	if len(segments) != 3 {
+		return fmt.Errorf("expected <project>/<zone>/<instance-name>")
+	}
+
+	i.Project = segments[0]
+	i.Zone = segments[1]
+	i.ID = segments[2]
+	return nil
+}
+
+// parseAzure parses an Azure resource ID of a VM or a VM scale set instance.
+func (i *Instance) parseAzure(path string) error {
+	segments := pathSegments(path)
+	if len(segments)%2 != 0 {
+		return fmt.Errorf("expected azure resource ID")
+	}
+
+	for j := 0; j < len(segments); j += 2 {
+		value := segments[j+1]
+		switch strings.ToLower(segments[j]) {
+		case "subscriptions":
+			i.SubscriptionID = value
+		case "resourcegroups":
+			i.ResourceGroup = value
+		case "virtualmachinescalesets":
+			i.ScaleSet = value
+		case "virtualmachines":
+			i.ID = value
+		}
+	}
+
+	if i.SubscriptionID == "" || i.ResourceGroup == "" || i.ID == "" {
+		return fmt.Errorf("expected subscription, resource group and virtual machine")
+	}
+	return nil
+}
+
+// EC2InstanceID returns the EC2 instance ID of an AWS instance. An error is
+// returned for other providers and for nodes not backed by an EC2 instance
+// such as Fargate nodes.
+func (i *Instance) EC2InstanceID() (string, error) {
+	if i.Provider != AWS {
+		return "", fmt.Errorf("expected %s provider, got %s", AWS, i.Provider)
+	}
+
+	if !strings.HasPrefix(i.ID, "i-") {
+		return "", fmt.Errorf("%s is not an EC2 instance", i.ID)
+	}
+	return i.ID, nil
+}
+
+// String returns the provider ID of the instance.
+func (i *Instance) String() string {
+	return i.ProviderID
+}
+
+// pathSegments returns the non-empty segments of the path.
+func pathSegments(path string) []string {
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// EC2InstanceID parses the provider ID and returns the EC2 instance ID.
+func EC2InstanceID(providerID string) (string, error) {
+	instance, err := Parse(providerID)
+	if err != nil {
+		return "", err
+	}
+	return instance.EC2InstanceID()
+}
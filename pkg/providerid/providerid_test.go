@@ -0,0 +1,93 @@
+package providerid
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		msg        string
+		providerID string
+		instance   *Instance
+		ec2        bool
+	}{
+		{
+			msg:        "aws provider ID with zone",
+			providerID: "aws:///eu-central-1a/i-1234",
+			instance:   &Instance{Provider: AWS, Zone: "eu-central-1a", ID: "i-1234"},
+			ec2:        true,
+		},
+		{
+			msg:        "aws provider ID without zone",
+			providerID: "aws:///i-1234",
+			instance:   &Instance{Provider: AWS, ID: "i-1234"},
+			ec2:        true,
+		},
+		{
+			msg:        "aws fargate provider ID",
+			providerID: "aws:///eu-central-1a/fargate-ip-10-0-0-1.eu-central-1.compute.internal",
+			instance:   &Instance{Provider: AWS, Zone: "eu-central-1a", ID: "fargate-ip-10-0-0-1.eu-central-1.compute.internal"},
+		},
+		{
+			msg:        "gce provider ID",
+			providerID: "gce://project/europe-west1-b/node-1",
+			instance:   &Instance{Provider: GCE, Project: "project", Zone: "europe-west1-b", ID: "node-1"},
+		},
+		{
+			msg:        "azure vm provider ID",
+			providerID: "azure:///subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachines/node-1",
+			instance:   &Instance{Provider: Azure, SubscriptionID: "sub", ResourceGroup: "group", ID: "node-1"},
+		},
+		{
+			msg:        "azure vmss provider ID",
+			providerID: "azure:///subscriptions/sub/resourceGroups/group/providers/Microsoft.Compute/virtualMachineScaleSets/pool/virtualMachines/3",
+			instance:   &Instance{Provider: Azure, SubscriptionID: "sub", ResourceGroup: "group", ScaleSet: "pool", ID: "3"},
+		},
+		{
+			msg:        "other provider ID",
+			providerID: "openstack:///a0b1c2",
+			instance:   &Instance{Provider: "openstack", ID: "a0b1c2"},
+		},
+		{
+			msg:        "invalid provider ID",
+			providerID: "i-1234",
+		},
+		{
+			msg:        "invalid gce provider ID",
+			providerID: "gce://project/node-1",
+		},
+		{
+			msg:        "invalid azure provider ID",
+			providerID: "azure:///subscriptions/sub",
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			instance, err := Parse(tc.providerID)
+			if tc.instance == nil {
+				if err == nil {
+					t.Error("expected failure")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("should not fail: %s", err)
+			}
+
+			tc.instance.ProviderID = tc.providerID
+			if !reflect.DeepEqual(instance, tc.instance) {
+				t.Errorf("expected %#v, got %#v", tc.instance, instance)
+			}
+
+			_, err = instance.EC2InstanceID()
+			if err != nil && tc.ec2 {
+				t.Errorf("should not fail: %s", err)
+			}
+
+			if err == nil && !tc.ec2 {
+				t.Error("expected failure")
+			}
+		})
+	}
+}
@@ -1,16 +1,237 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
-	yaml "gopkg.in/yaml.v2"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+)
+
+const (
+	// ReadinessModeCondition evaluates pod readiness based on the PodReady
+	// condition. This takes readiness gates into account.
+	ReadinessModeCondition = "condition"
+	// ReadinessModeContainers evaluates pod readiness based on the ready
+	// status of all containers in the pod.
+	ReadinessModeContainers = "containers"
 )
 
 // PodSelector consist of namespace and labels that can identify a Pod.
 type PodSelector struct {
-	Namespace string
-	Labels    map[string]string
+	// SelectorName identifies the selector in events and metrics.
+	// Defaults to <namespace>:<key>=<value>,+.
+	SelectorName string            `yaml:"name"`
+	Namespace    string            `yaml:"namespace"`
+	Labels       map[string]string `yaml:"labels"`
+	// ReadinessMode defines how the readiness of a selected pod is
+	// evaluated. Defaults to ReadinessModeCondition.
+	ReadinessMode string `yaml:"readinessMode"`
+	// RequireRunning requires the pod to be in the Running phase to be
+	// considered ready.
+	RequireRunning bool `yaml:"requireRunning"`
+	// AllowTerminating considers pods with a deletionTimestamp ready as
+	// long as they otherwise satisfy the readiness requirements.
+	AllowTerminating bool `yaml:"allowTerminating"`
+	// MinReadySeconds is the minimum number of seconds the PodReady
+	// condition must have been true for the pod to be considered ready.
+	MinReadySeconds int `yaml:"minReadySeconds"`
+	// MinReady is the minimum number of ready pods matching the selector
+	// which must be on the node. Defaults to 1.
+	MinReady int `yaml:"minReady"`
+	// MaxNotReady is the maximum number of not ready pods matching the
+	// selector allowed on the node. Unlimited if not set.
+	MaxNotReady *int `yaml:"maxNotReady"`
+	// Optional marks the selector as a soft requirement. An optional
+	// selector which is not satisfied never keeps the node not ready once
+	// Timeout has elapsed since the node was created.
+	Optional bool `yaml:"optional"`
+	// Timeout is the duration after node creation during which an optional
+	// selector blocks the node from becoming ready. If not set an optional
+	// selector never blocks.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Name returns the name identifying the selector.
+func (s *PodSelector) Name() string {
+	if s.SelectorName != "" {
+		return s.SelectorName
+	}
+	return s.labelSelector()
+}
+
+// Evaluate counts the ready and not ready pods matching the selector on the
+// node.
+func (s *PodSelector) Evaluate(ctx context.Context, node *v1.Node, snapshot *NodeSnapshot) (*CheckResult, error) {
+	result := &SelectorResult{Selector: s}
+	var readyTimes []time.Time
+	for _, pod := range snapshot.Pods {
+		if pod.ObjectMeta.Namespace == s.Namespace &&
+			containLabels(pod.ObjectMeta.Labels, s.Labels) {
+			if podReady(&pod, s, snapshot.Now) {
+				result.Ready++
+				if condition := podReadyCondition(&pod); condition != nil {
+					readyTimes = append(readyTimes, condition.LastTransitionTime.Time)
+				}
+			} else {
+				result.NotReady++
+				log.WithFields(log.Fields{
+					"pod":       pod.Name,
+					"namespace": pod.Namespace,
+					"node":      node.Name,
+				}).Warn("Pod not ready.")
+			}
+		}
+	}
+
+	nodeAge := snapshot.Now.Sub(node.ObjectMeta.CreationTimestamp.Time)
+
+	checkResult := &CheckResult{
+		Check:    s.Name(),
+		Ready:    result.Satisfied(),
+		Optional: s.Optional,
+		Blocking: result.Blocking(nodeAge),
+		Reason:   result.String(),
+	}
+
+	// the selector became ready when the minReady-th pod became ready.
+	if checkResult.Ready && len(readyTimes) >= s.minReady() {
+		sort.Slice(readyTimes, func(i, j int) bool {
+			return readyTimes[i].Before(readyTimes[j])
+		})
+		checkResult.ReadySince = readyTimes[s.minReady()-1]
+	}
+
+	return checkResult, nil
+}
+
+// SelectorResult describes the readiness of the pods matching a pod selector
+// on a node.
+type SelectorResult struct {
+	Selector *PodSelector
+	Ready    int
+	NotReady int
+}
+
+// Satisfied returns true if the number of ready and not ready pods satisfy
+// the requirements of the selector.
+func (r *SelectorResult) Satisfied() bool {
+	if r.Ready < r.Selector.minReady() {
+		return false
+	}
+
+	if r.Selector.MaxNotReady != nil && r.NotReady > *r.Selector.MaxNotReady {
+		return false
+	}
+
+	return true
+}
+
+// Blocking returns true if the selector is not satisfied and should keep a
+// node of the given age not ready. Optional selectors only block until their
+// timeout has elapsed.
+func (r *SelectorResult) Blocking(nodeAge time.Duration) bool {
+	if r.Satisfied() {
+		return false
+	}
+
+	if !r.Selector.Optional {
+		return true
+	}
+
+	return nodeAge < r.Selector.Timeout
+}
+
+// String returns a human readable description of the actual and required
+// pod counts.
+func (r *SelectorResult) String() string {
+	str := fmt.Sprintf("%d/%d ready", r.Ready, r.Selector.minReady())
+	if r.Selector.MaxNotReady != nil {
+		str = fmt.Sprintf("%s, %d/%d not ready", str, r.NotReady, *r.Selector.MaxNotReady)
+	}
+	return str
+}
+
+// labelSelector returns the selector in the format
+// <namespace>:<key>=<value>,+.
+func (s *PodSelector) labelSelector() string {
+	labels := make([]string, 0, len(s.Labels))
+	for k, v := range s.Labels {
+		labels = append(labels, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(labels)
+
+	return fmt.Sprintf("%s:%s", s.Namespace, strings.Join(labels, ","))
+}
+
+// minReady returns the minimum number of ready pods required for the
+// selector.
+func (s *PodSelector) minReady() int {
+	if s.MinReady > 0 {
+		return s.MinReady
+	}
+	return 1
+}
+
+// String returns the selector in the format
+// <namespace>:<key>=<value>,+[:<option>=<value>,+].
+func (s *PodSelector) String() string {
+	str := s.labelSelector()
+
+	var options []string
+	if s.MinReady > 0 {
+		options = append(options, fmt.Sprintf("minReady=%d", s.MinReady))
+	}
+	if s.MaxNotReady != nil {
+		options = append(options, fmt.Sprintf("maxNotReady=%d", *s.MaxNotReady))
+	}
+	if s.Optional {
+		options = append(options, "optional=true")
+	}
+	if s.Timeout > 0 {
+		options = append(options, fmt.Sprintf("timeout=%s", s.Timeout))
+	}
+
+	if len(options) > 0 {
+		str = fmt.Sprintf("%s:%s", str, strings.Join(options, ","))
+	}
+
+	return str
+}
+
+// validate validates the readiness settings of the pod selector.
+func (s *PodSelector) validate() error {
+	switch s.ReadinessMode {
+	case "", ReadinessModeCondition, ReadinessModeContainers:
+	default:
+		return fmt.Errorf("invalid readiness mode '%s' for pod selector", s.ReadinessMode)
+	}
+
+	if s.MinReadySeconds < 0 {
+		return fmt.Errorf("minReadySeconds must not be negative")
+	}
+
+	if s.MinReady < 0 {
+		return fmt.Errorf("minReady must not be negative")
+	}
+
+	if s.MaxNotReady != nil && *s.MaxNotReady < 0 {
+		return fmt.Errorf("maxNotReady must not be negative")
+	}
+
+	if s.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+
+	if s.Timeout > 0 && !s.Optional {
+		return fmt.Errorf("timeout is only supported for optional selectors")
+	}
+
+	return nil
 }
 
 // PodSelectors is a list of PodSelector definitions.
@@ -19,20 +240,18 @@ type PodSelectors []*PodSelector
 func (p PodSelectors) String() string {
 	strs := make([]string, len(p))
 	for i, t := range p {
-		labels := make([]string, 0, len(t.Labels))
-		for k, v := range t.Labels {
-			labels = append(labels, fmt.Sprintf("%s=%s", k, v))
-		}
-		strs[i] = fmt.Sprintf("%s:%s", t.Namespace, strings.Join(labels, ","))
+		strs[i] = t.String()
 	}
 
 	return strings.Join(strs, " - ")
 }
 
-// Set parses a pod selector string and adds it to the list.
+// Set parses a pod selector string and adds it to the list. The format is
+// <namespace>:<key>=<value>,+[:<option>=<value>,+] where the supported options
+// are minReady, maxNotReady, optional and timeout.
 func (p *PodSelectors) Set(value string) error {
 	divide := strings.Split(value, ":")
-	if len(divide) != 2 {
+	if len(divide) < 2 || len(divide) > 3 {
 		return fmt.Errorf("invalid pod selector format")
 	}
 
@@ -48,7 +267,53 @@ func (p *PodSelectors) Set(value string) error {
 		labels[kv[0]] = kv[1]
 	}
 
-	*p = append(*p, &PodSelector{Namespace: namespace, Labels: labels})
+	selector := &PodSelector{Namespace: namespace, Labels: labels}
+
+	if len(divide) == 3 {
+		err := selector.setOptions(divide[2])
+		if err != nil {
+			return err
+		}
+	}
+
+	err := selector.validate()
+	if err != nil {
+		return err
+	}
+
+	*p = append(*p, selector)
+
+	return nil
+}
+
+// setOptions parses a list of selector options in the format
+// <option>=<value>,+.
+func (s *PodSelector) setOptions(value string) error {
+	for _, optionStr := range strings.Split(value, ",") {
+		kv := strings.Split(optionStr, "=")
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid pod selector option format")
+		}
+
+		var err error
+		switch kv[0] {
+		case "minReady":
+			s.MinReady, err = strconv.Atoi(kv[1])
+		case "maxNotReady":
+			var n int
+			n, err = strconv.Atoi(kv[1])
+			s.MaxNotReady = &n
+		case "optional":
+			s.Optional, err = strconv.ParseBool(kv[1])
+		case "timeout":
+			s.Timeout, err = time.ParseDuration(kv[1])
+		default:
+			return fmt.Errorf("unknown pod selector option '%s'", kv[0])
+		}
+		if err != nil {
+			return fmt.Errorf("invalid value for pod selector option '%s': %v", kv[0], err)
+		}
+	}
 
 	return nil
 }
@@ -59,21 +324,12 @@ func (p PodSelectors) IsCumulative() bool {
 	return true
 }
 
-type selectors struct {
-	Selectors []*PodSelector `yaml:"selectors"`
-}
-
-// ReadSelectors reads selectors defined as a yaml in the following format:
-//
-// selectors:
-// - namespace: kube-system
-//   labels:
-//     foo: bar
+// ReadSelectors reads the selectors of a config defined as a yaml. See
+// ReadConfig for the format.
 func ReadSelectors(data string) ([]*PodSelector, error) {
-	var s selectors
-	err := yaml.Unmarshal([]byte(data), &s)
+	config, err := ReadConfig(data)
 	if err != nil {
 		return nil, err
 	}
-	return s.Selectors, nil
+	return config.Selectors, nil
 }
@@ -1,6 +1,14 @@
 package main
 
-import "testing"
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
 
 func TestPodSelectorString(t *testing.T) {
 	PodSelectors := PodSelectors([]*PodSelector{
@@ -28,6 +36,36 @@ func TestSetPodSelectorValue(t *testing.T) {
 			value: "kube-system:application=skipper-ingress",
 			valid: true,
 		},
+		{
+			msg:   "test valid selector with options",
+			value: "kube-system:application=dns:minReady=2,maxNotReady=0",
+			valid: true,
+		},
+		{
+			msg:   "test valid optional selector with timeout",
+			value: "kube-system:application=logging-agent:optional=true,timeout=5m",
+			valid: true,
+		},
+		{
+			msg:   "test invalid required selector with timeout",
+			value: "kube-system:application=logging-agent:timeout=5m",
+			valid: false,
+		},
+		{
+			msg:   "test invalid selector with unknown option",
+			value: "kube-system:application=dns:foo=2",
+			valid: false,
+		},
+		{
+			msg:   "test invalid selector with invalid option value",
+			value: "kube-system:application=dns:minReady=two",
+			valid: false,
+		},
+		{
+			msg:   "test invalid selector with negative option value",
+			value: "kube-system:application=dns:minReady=-1",
+			valid: false,
+		},
 		{
 			msg:   "test invalid selector with missing labels",
 			value: "kube-system",
@@ -53,6 +91,19 @@ func TestSetPodSelectorValue(t *testing.T) {
 	}
 }
 
+func TestPodSelectorStringOptions(t *testing.T) {
+	podSelectors := PodSelectors([]*PodSelector{})
+	value := "kube-system:application=dns:minReady=2,maxNotReady=0"
+	err := podSelectors.Set(value)
+	if err != nil {
+		t.Errorf("should not fail: %s", err)
+	}
+
+	if podSelectors.String() != value {
+		t.Errorf("expected %s, got %s", value, podSelectors.String())
+	}
+}
+
 func TestPodSelectorIsCumulative(t *testing.T) {
 	podSelectors := PodSelectors([]*PodSelector{})
 	if !podSelectors.IsCumulative() {
@@ -89,4 +140,206 @@ func TestReadSelectors(t *testing.T) {
 	if err == nil {
 		t.Errorf("expected error")
 	}
+
+	const invalidModeData = `selectors:
+- namespace: kube-system
+  labels:
+    foo: bar
+  readinessMode: unknown`
+	_, err = ReadSelectors(invalidModeData)
+	if err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestReadSelectorsReadiness(t *testing.T) {
+	const data = `selectors:
+- namespace: kube-system
+  labels:
+    foo: bar
+  readinessMode: containers
+  requireRunning: true
+  allowTerminating: true
+  minReadySeconds: 10
+  minReady: 2
+  maxNotReady: 0`
+
+	selectors, err := ReadSelectors(data)
+	if err != nil {
+		t.Errorf("should not fail: %s", err)
+	}
+
+	maxNotReady := 0
+	expected := &PodSelector{
+		Namespace:        "kube-system",
+		Labels:           map[string]string{"foo": "bar"},
+		ReadinessMode:    ReadinessModeContainers,
+		RequireRunning:   true,
+		AllowTerminating: true,
+		MinReadySeconds:  10,
+		MinReady:         2,
+		MaxNotReady:      &maxNotReady,
+	}
+
+	if !reflect.DeepEqual(selectors[0], expected) {
+		t.Errorf("expected %#v, got %#v", expected, selectors[0])
+	}
+}
+
+func TestSelectorResultSatisfied(t *testing.T) {
+	zero := 0
+	for _, tc := range []struct {
+		msg       string
+		result    *SelectorResult
+		satisfied bool
+	}{
+		{
+			msg: "one ready pod should satisfy default selector",
+			result: &SelectorResult{
+				Selector: &PodSelector{},
+				Ready:    1,
+				NotReady: 1,
+			},
+			satisfied: true,
+		},
+		{
+			msg: "no ready pods should not satisfy default selector",
+			result: &SelectorResult{
+				Selector: &PodSelector{},
+			},
+			satisfied: false,
+		},
+		{
+			msg: "fewer than minReady pods should not satisfy selector",
+			result: &SelectorResult{
+				Selector: &PodSelector{MinReady: 2},
+				Ready:    1,
+			},
+			satisfied: false,
+		},
+		{
+			msg: "minReady pods should satisfy selector",
+			result: &SelectorResult{
+				Selector: &PodSelector{MinReady: 2},
+				Ready:    2,
+			},
+			satisfied: true,
+		},
+		{
+			msg: "more than maxNotReady pods should not satisfy selector",
+			result: &SelectorResult{
+				Selector: &PodSelector{MaxNotReady: &zero},
+				Ready:    1,
+				NotReady: 1,
+			},
+			satisfied: false,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			if tc.result.Satisfied() != tc.satisfied {
+				t.Errorf("expected satisfied %t, got %t", tc.satisfied, !tc.satisfied)
+			}
+		})
+	}
+}
+
+func TestSelectorResultBlocking(t *testing.T) {
+	for _, tc := range []struct {
+		msg      string
+		result   *SelectorResult
+		nodeAge  time.Duration
+		blocking bool
+	}{
+		{
+			msg: "unsatisfied required selector should block",
+			result: &SelectorResult{
+				Selector: &PodSelector{},
+			},
+			nodeAge:  time.Hour,
+			blocking: true,
+		},
+		{
+			msg: "unsatisfied optional selector without timeout should not block",
+			result: &SelectorResult{
+				Selector: &PodSelector{Optional: true},
+			},
+			blocking: false,
+		},
+		{
+			msg: "unsatisfied optional selector should block until timeout",
+			result: &SelectorResult{
+				Selector: &PodSelector{Optional: true, Timeout: 5 * time.Minute},
+			},
+			nodeAge:  time.Minute,
+			blocking: true,
+		},
+		{
+			msg: "unsatisfied optional selector should not block after timeout",
+			result: &SelectorResult{
+				Selector: &PodSelector{Optional: true, Timeout: 5 * time.Minute},
+			},
+			nodeAge:  10 * time.Minute,
+			blocking: false,
+		},
+		{
+			msg: "satisfied selector should not block",
+			result: &SelectorResult{
+				Selector: &PodSelector{},
+				Ready:    1,
+			},
+			blocking: false,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			if tc.result.Blocking(tc.nodeAge) != tc.blocking {
+				t.Errorf("expected blocking %t, got %t", tc.blocking, !tc.blocking)
+			}
+		})
+	}
+}
+
+func TestPodSelectorEvaluateReadySince(t *testing.T) {
+	now := time.Now().UTC()
+	pod := func(readySince time.Time) v1.Pod {
+		return v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "kube-system",
+				Labels:    map[string]string{"foo": "bar"},
+			},
+			Status: v1.PodStatus{
+				Conditions: []v1.PodCondition{
+					{
+						Type:               v1.PodReady,
+						Status:             v1.ConditionTrue,
+						LastTransitionTime: metav1.NewTime(readySince),
+					},
+				},
+			},
+		}
+	}
+
+	selector := &PodSelector{
+		Namespace: "kube-system",
+		Labels:    map[string]string{"foo": "bar"},
+		MinReady:  2,
+	}
+
+	snapshot := &NodeSnapshot{
+		Now: now,
+		Pods: []v1.Pod{
+			pod(now.Add(-time.Minute)),
+			pod(now.Add(-3 * time.Minute)),
+			pod(now.Add(-2 * time.Minute)),
+		},
+	}
+
+	result, err := selector.Evaluate(context.Background(), &v1.Node{}, snapshot)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	expected := now.Add(-2 * time.Minute)
+	if !result.ReadySince.Equal(expected) {
+		t.Errorf("expected selector to be ready since %s, got %s", expected, result.ReadySince)
+	}
 }
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/api/core/v1"
+)
+
+const (
+	// ProbeTypeHTTP probes an endpoint with a HTTP GET request.
+	ProbeTypeHTTP = "http"
+	// ProbeTypeTCP probes an endpoint by opening a TCP connection.
+	ProbeTypeTCP = "tcp"
+
+	// ProbeTargetNode probes the internal IP of the node.
+	ProbeTargetNode = "node"
+	// ProbeTargetPod probes the pod IPs of the pods matching the probe's
+	// namespace and labels.
+	ProbeTargetPod = "pod"
+
+	defaultProbeTimeout = 1 * time.Second
+	// maxConcurrentProbes is the maximum number of hosts probed
+	// concurrently by a single probe.
+	maxConcurrentProbes = 10
+)
+
+// Probe defines an HTTP or TCP probe executed by the controller against an
+// endpoint on the node.
+type Probe struct {
+	ProbeName string `yaml:"name"`
+	// Type is the type of probe, http or tcp. Defaults to http.
+	Type string `yaml:"type"`
+	// Target is the target of the probe, node or pod. Defaults to node.
+	Target string `yaml:"target"`
+	// Namespace and Labels select the pods to probe when the target is
+	// pod.
+	Namespace string            `yaml:"namespace"`
+	Labels    map[string]string `yaml:"labels"`
+	Port      int               `yaml:"port"`
+	// Path is the HTTP path to request. Defaults to /.
+	Path string `yaml:"path"`
+	// Scheme is the HTTP scheme, http or https. Defaults to http.
+	Scheme string `yaml:"scheme"`
+	// ExpectedStatusCodes are the HTTP status codes considered
+	// successful. Defaults to any status code in the range 200-399.
+	ExpectedStatusCodes []int `yaml:"expectedStatusCodes"`
+	// Timeout is the timeout of the probe. Defaults to 1s.
+	Timeout time.Duration `yaml:"timeout"`
+	// Prober executes the probe. Defaults to probing over the network.
+	Prober Prober `yaml:"-"`
+}
+
+// Name returns the name of the probe.
+func (p *Probe) Name() string {
+	return p.ProbeName
+}
+
+// Evaluate executes the probe against the node or the matching pods on the
+// node. The hosts are probed concurrently, each bounded by the probe timeout.
+func (p *Probe) Evaluate(ctx context.Context, node *v1.Node, snapshot *NodeSnapshot) (*CheckResult, error) {
+	prober := p.Prober
+	if prober == nil {
+		prober = NewProber()
+	}
+
+	hosts := p.hosts(node, snapshot.Pods)
+	if len(hosts) == 0 {
+		return newCheckResult(p, []string{fmt.Sprintf("no %s to probe", p.target())}), nil
+	}
+
+	errs := make([]error, len(hosts))
+	sem := make(chan struct{}, maxConcurrentProbes)
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, host string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			probeCtx, cancel := context.WithTimeout(ctx, p.timeout())
+			defer cancel()
+			errs[i] = prober.Probe(probeCtx, p, host)
+		}(i, host)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("probe against %s failed: %v", hosts[i], err))
+		}
+	}
+
+	return newCheckResult(p, failed), nil
+}
+
+// validate validates the probe definition.
+func (p *Probe) validate() error {
+	if p.ProbeName == "" {
+		return fmt.Errorf("probe name must be specified")
+	}
+
+	switch p.Type {
+	case "", ProbeTypeHTTP, ProbeTypeTCP:
+	default:
+		return fmt.Errorf("invalid type '%s' for probe '%s'", p.Type, p.ProbeName)
+	}
+
+	switch p.Target {
+	case "", ProbeTargetNode:
+	case ProbeTargetPod:
+		if p.Namespace == "" || len(p.Labels) == 0 {
+			return fmt.Errorf("namespace and labels must be specified for pod target of probe '%s'", p.ProbeName)
+		}
+	default:
+		return fmt.Errorf("invalid target '%s' for probe '%s'", p.Target, p.ProbeName)
+	}
+
+	switch p.Scheme {
+	case "", "http", "https":
+	default:
+		return fmt.Errorf("invalid scheme '%s' for probe '%s'", p.Scheme, p.ProbeName)
+	}
+
+	if p.Port <= 0 || p.Port > 65535 {
+		return fmt.Errorf("invalid port %d for probe '%s'", p.Port, p.ProbeName)
+	}
+
+	if p.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative for probe '%s'", p.ProbeName)
+	}
+
+	return nil
+}
+
+// timeout returns the probe timeout.
+func (p *Probe) timeout() time.Duration {
+	if p.Timeout > 0 {
+		return p.Timeout
+	}
+	return defaultProbeTimeout
+}
+
+// hosts returns the hosts to probe based on the probe target.
+func (p *Probe) hosts(node *v1.Node, pods []v1.Pod) []string {
+	if p.Target != ProbeTargetPod {
+		if ip := nodeInternalIP(node); ip != "" {
+			return []string{ip}
+		}
+		return nil
+	}
+
+	var hosts []string
+	for _, pod := range pods {
+		if pod.ObjectMeta.Namespace == p.Namespace &&
+			containLabels(pod.ObjectMeta.Labels, p.Labels) &&
+			pod.Status.PodIP != "" {
+			hosts = append(hosts, pod.Status.PodIP)
+		}
+	}
+	return hosts
+}
+
+// Prober describes a prober which can execute a probe against a host.
+type Prober interface {
+	Probe(ctx context.Context, probe *Probe, host string) error
+}
+
+// netProber executes probes over the network.
+type netProber struct{}
+
+// NewProber initializes a new Prober.
+func NewProber() Prober {
+	return &netProber{}
+}
+
+// Probe executes the probe against the host and returns an error if the probe
+// failed.
+func (p *netProber) Probe(ctx context.Context, probe *Probe, host string) error {
+	address := net.JoinHostPort(host, strconv.Itoa(probe.Port))
+
+	if probe.Type == ProbeTypeTCP {
+		dialer := &net.Dialer{Timeout: probe.timeout()}
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	scheme := probe.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	path := probe.Path
+	if path == "" {
+		path = "/"
+	}
+
+	client := &http.Client{
+		Timeout: probe.timeout(),
+		Transport: &http.Transport{
+			// like the kubelet, don't verify certificates of
+			// https probes.
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s://%s%s", scheme, address, path), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !expectedStatusCode(probe.ExpectedStatusCodes, resp.StatusCode) {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// expectedStatusCode returns true if the status code is one of the expected
+// status codes or in the range 200-399 if no status codes are expected.
+func expectedStatusCode(expected []int, statusCode int) bool {
+	if len(expected) == 0 {
+		return statusCode >= http.StatusOK && statusCode < http.StatusBadRequest
+	}
+
+	for _, code := range expected {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// target returns the target of the probe.
+func (p *Probe) target() string {
+	if p.Target == "" {
+		return ProbeTargetNode
+	}
+	return p.Target
+}
+
+// nodeInternalIP returns the internal IP of the node or an empty string if
+// the node has no internal IP.
+func nodeInternalIP(node *v1.Node) string {
+	for _, address := range node.Status.Addresses {
+		if address.Type == v1.NodeInternalIP {
+			return address.Address
+		}
+	}
+	return ""
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNetProber(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	host, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	for _, tc := range []struct {
+		msg     string
+		probe   *Probe
+		success bool
+	}{
+		{
+			msg:     "http probe should succeed",
+			probe:   &Probe{ProbeName: "foo", Port: port, Path: "/healthz"},
+			success: true,
+		},
+		{
+			msg:     "http probe should fail on unexpected status code",
+			probe:   &Probe{ProbeName: "foo", Port: port, Path: "/"},
+			success: false,
+		},
+		{
+			msg: "http probe should succeed on expected status code",
+			probe: &Probe{
+				ProbeName:           "foo",
+				Port:                port,
+				Path:                "/",
+				ExpectedStatusCodes: []int{http.StatusServiceUnavailable},
+			},
+			success: true,
+		},
+		{
+			msg:     "tcp probe should succeed",
+			probe:   &Probe{ProbeName: "foo", Type: ProbeTypeTCP, Port: port},
+			success: true,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			err := NewProber().Probe(context.Background(), tc.probe, host)
+			if err != nil && tc.success {
+				t.Errorf("should not fail: %s", err)
+			}
+
+			if err == nil && !tc.success {
+				t.Error("expected failure")
+			}
+		})
+	}
+}
+
+type mockProber struct {
+	probed []string
+}
+
+func (p *mockProber) Probe(ctx context.Context, probe *Probe, host string) error {
+	p.probed = append(p.probed, host)
+	return nil
+}
+
+func TestProbeEvaluate(t *testing.T) {
+	node := &v1.Node{
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+			},
+		},
+	}
+
+	pods := []v1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "kube-system",
+				Labels:    map[string]string{"application": "skipper"},
+			},
+			Status: v1.PodStatus{PodIP: "10.2.0.1"},
+		},
+	}
+
+	prober := &mockProber{}
+	probes := []*Probe{
+		{ProbeName: "node", Port: 80, Prober: prober},
+		{
+			ProbeName: "pod",
+			Target:    ProbeTargetPod,
+			Namespace: "kube-system",
+			Labels:    map[string]string{"application": "skipper"},
+			Port:      9999,
+			Prober:    prober,
+		},
+		{
+			ProbeName: "missing",
+			Target:    ProbeTargetPod,
+			Namespace: "kube-system",
+			Labels:    map[string]string{"application": "missing"},
+			Port:      9999,
+			Prober:    prober,
+		},
+	}
+
+	snapshot := &NodeSnapshot{Pods: pods}
+	var failed []*CheckResult
+	for _, probe := range probes {
+		result, err := probe.Evaluate(context.Background(), node, snapshot)
+		if err != nil {
+			t.Errorf("should not fail: %s", err)
+		}
+
+		if !result.Ready {
+			failed = append(failed, result)
+		}
+	}
+
+	if len(failed) != 1 || failed[0].Check != "missing" {
+		t.Errorf("expected probe 'missing' to fail, got %v", failed)
+	}
+
+	if len(prober.probed) != 2 || prober.probed[0] != "10.0.0.1" || prober.probed[1] != "10.2.0.1" {
+		t.Errorf("expected hosts 10.0.0.1 and 10.2.0.1 to be probed, got %v", prober.probed)
+	}
+}
+
+func TestProbeValidate(t *testing.T) {
+	for _, tc := range []struct {
+		msg   string
+		probe *Probe
+		valid bool
+	}{
+		{
+			msg:   "valid probe",
+			probe: &Probe{ProbeName: "foo", Port: 80},
+			valid: true,
+		},
+		{
+			msg:   "probe without port should be invalid",
+			probe: &Probe{ProbeName: "foo"},
+			valid: false,
+		},
+		{
+			msg:   "probe with invalid type should be invalid",
+			probe: &Probe{ProbeName: "foo", Port: 80, Type: "udp"},
+			valid: false,
+		},
+		{
+			msg:   "pod probe without labels should be invalid",
+			probe: &Probe{ProbeName: "foo", Port: 80, Target: ProbeTargetPod, Namespace: "kube-system"},
+			valid: false,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			err := tc.probe.validate()
+			if err != nil && tc.valid {
+				t.Errorf("should not fail: %s", err)
+			}
+
+			if err == nil && !tc.valid {
+				t.Error("expected failure")
+			}
+		})
+	}
+}
+
+// slowProber blocks until the context is done.
+type slowProber struct{}
+
+func (p *slowProber) Probe(ctx context.Context, probe *Probe, host string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestRunOnceSlowProbes(t *testing.T) {
+	client := setupMockKubernetes(t, nil, nil)
+	for i := 0; i < 50; i++ {
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-" + strconv.Itoa(i)},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{
+					{Type: v1.NodeInternalIP, Address: "10.0.0." + strconv.Itoa(i)},
+				},
+			},
+		}
+		_, err := client.CoreV1().Nodes().Create(node)
+		if err != nil {
+			t.Fatalf("should not fail: %s", err)
+		}
+	}
+
+	controller := &NodeController{
+		Interface: client,
+		stages: []*Stage{
+			{
+				Name:  defaultStageName,
+				Taint: taintNodeNotReadyName,
+				Checks: []ReadinessCheck{
+					&Probe{ProbeName: "slow", Port: 80, Timeout: 50 * time.Millisecond, Prober: &slowProber{}},
+				},
+			},
+		},
+	}
+
+	start := time.Now()
+	err := controller.runOnce()
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	// 50 nodes evaluated 10 at a time with a 50ms probe timeout.
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected pass to be bounded by the probe timeout, took %s", elapsed)
+	}
+
+	// every node is reconciled in the pass and kept tainted by the timed out
+	// probe.
+	nodes, err := client.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	for _, node := range nodes.Items {
+		if !hasTaint(&node, taintNodeNotReadyName) {
+			t.Errorf("expected node %s to be tainted by the timed out probe", node.Name)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+	"k8s.io/api/core/v1"
+)
+
+// ReadinessCheck describes a check which evaluates a readiness requirement
+// of a node.
+type ReadinessCheck interface {
+	// Name returns the name identifying the check in logs, events and
+	// metrics.
+	Name() string
+	// Evaluate evaluates the check for the node based on the node
+	// snapshot.
+	Evaluate(ctx context.Context, node *v1.Node, snapshot *NodeSnapshot) (*CheckResult, error)
+}
+
+// checkTypes is the registry of readiness check types which can be defined in
+// the checks section of the config, keyed by the type name.
+var checkTypes = map[string]func() ReadinessCheck{
+	"pods":              func() ReadinessCheck { return &PodSelector{} },
+	"nodePrerequisites": func() ReadinessCheck { return &NodePrerequisites{} },
+	"csiDrivers":        func() ReadinessCheck { return &CSIDriversCheck{} },
+	"httpProbe":         func() ReadinessCheck { return &Probe{Type: ProbeTypeHTTP} },
+	"tcpProbe":          func() ReadinessCheck { return &Probe{Type: ProbeTypeTCP} },
+	"images":            func() ReadinessCheck { return &ImageRequirements{} },
+}
+
+// checkConfig is a readiness check defined in the config by its type.
+type checkConfig struct {
+	ReadinessCheck
+}
+
+// UnmarshalYAML unmarshals a readiness check based on its type field. The
+// remaining fields are unmarshaled into the check registered for the type.
+func (c *checkConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw map[string]interface{}
+	err := unmarshal(&raw)
+	if err != nil {
+		return err
+	}
+
+	checkType, _ := raw["type"].(string)
+	newCheck, ok := checkTypes[checkType]
+	if !ok {
+		return fmt.Errorf("unknown check type '%s', must be one of: %s", checkType, strings.Join(checkTypeNames(), ", "))
+	}
+
+	// remove the type such that it doesn't conflict with fields of the
+	// check.
+	delete(raw, "type")
+	data, err := yaml.Marshal(raw)
+	if err != nil {
+		return err
+	}
+
+	check := newCheck()
+	err = yaml.Unmarshal(data, check)
+	if err != nil {
+		return err
+	}
+
+	c.ReadinessCheck = check
+	return nil
+}
+
+// checkTypeNames returns the sorted names of the registered check types.
+func checkTypeNames() []string {
+	names := make([]string, 0, len(checkTypes))
+	for name := range checkTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateCheck validates the check if it supports validation.
+func validateCheck(check ReadinessCheck) error {
+	if v, ok := check.(interface {
+		validate() error
+	}); ok {
+		return v.validate()
+	}
+	return nil
+}
+
+// CheckResult is the result of evaluating a readiness check.
+type CheckResult struct {
+	// Check is the name of the evaluated check.
+	Check string
+	// Ready is true if the requirement of the check is met.
+	Ready bool
+	// Optional is true if the check is a soft requirement.
+	Optional bool
+	// Blocking is true if the result should keep the node not ready.
+	Blocking bool
+	// Reason describes the state of the requirement.
+	Reason string
+	// ReadySince is the time the requirement became ready if known.
+	ReadySince time.Time
+}
+
+// newCheckResult returns the result of a required check which is ready if no
+// unmet requirements are given.
+func newCheckResult(check ReadinessCheck, unmet []string) *CheckResult {
+	return &CheckResult{
+		Check:    check.Name(),
+		Ready:    len(unmet) == 0,
+		Blocking: len(unmet) > 0,
+		Reason:   strings.Join(unmet, "; "),
+	}
+}
+
+// String returns a human readable description of the result.
+func (r *CheckResult) String() string {
+	if r.Reason == "" {
+		return r.Check
+	}
+	return fmt.Sprintf("%s: %s", r.Check, r.Reason)
+}
+
+// NodeSnapshot is the state of a node shared by all readiness checks
+// evaluated for the node in a single pass.
+type NodeSnapshot struct {
+	// Now is the time of the evaluation.
+	Now time.Time
+	// Pods are the pods scheduled on the node.
+	Pods []v1.Pod
+
+	csiNodeGetter CSINodeGetter
+	csiDrivers    []CSINodeDriver
+	csiLoaded     bool
+}
+
+// NewNodeSnapshot initializes a new NodeSnapshot.
+func NewNodeSnapshot(now time.Time, pods []v1.Pod, csiNodeGetter CSINodeGetter) *NodeSnapshot {
+	return &NodeSnapshot{
+		Now:           now,
+		Pods:          pods,
+		csiNodeGetter: csiNodeGetter,
+	}
+}
+
+// CSINodeDrivers returns the CSI drivers registered for the node. The drivers
+// are only looked up once per snapshot.
+func (s *NodeSnapshot) CSINodeDrivers(nodeName string) ([]CSINodeDriver, error) {
+	if s.csiLoaded {
+		return s.csiDrivers, nil
+	}
+
+	if s.csiNodeGetter == nil {
+		return nil, fmt.Errorf("no CSINode getter configured")
+	}
+
+	drivers, err := s.csiNodeGetter.CSINodeDrivers(nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	s.csiDrivers = drivers
+	s.csiLoaded = true
+	return drivers, nil
+}
+
+// evaluateChecks evaluates all checks for the node.
+func evaluateChecks(ctx context.Context, checks []ReadinessCheck, node *v1.Node, snapshot *NodeSnapshot) (*NodeReadiness, error) {
+	readiness := &NodeReadiness{
+		Results: make([]*CheckResult, 0, len(checks)),
+	}
+
+	for _, check := range checks {
+		result, err := check.Evaluate(ctx, node, snapshot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate check %s: %v", check.Name(), err)
+		}
+		readiness.Results = append(readiness.Results, result)
+	}
+
+	return readiness, nil
+}
+
+// NodeReadiness is the result of evaluating the readiness of a node.
+type NodeReadiness struct {
+	Results []*CheckResult
+}
+
+// Ready returns true if no check results are blocking the node.
+func (r *NodeReadiness) Ready() bool {
+	for _, result := range r.Results {
+		if result.Blocking {
+			return false
+		}
+	}
+	return true
+}
+
+// OptionalFailures returns the results of the optional checks which are not
+// ready.
+func (r *NodeReadiness) OptionalFailures() []*CheckResult {
+	var failures []*CheckResult
+	for _, result := range r.Results {
+		if result.Optional && !result.Ready {
+			failures = append(failures, result)
+		}
+	}
+	return failures
+}
+
+// NotReady returns the results of the checks which are not ready.
+func (r *NodeReadiness) NotReady() []*CheckResult {
+	var notReady []*CheckResult
+	for _, result := range r.Results {
+		if !result.Ready {
+			notReady = append(notReady, result)
+		}
+	}
+	return notReady
+}
+
+// String returns a human readable description of the checks which are not
+// ready.
+func (r *NodeReadiness) String() string {
+	notReady := r.NotReady()
+	strs := make([]string, 0, len(notReady))
+	for _, result := range notReady {
+		strs = append(strs, result.String())
+	}
+	return strings.Join(strs, "; ")
+}
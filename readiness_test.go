@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/api/core/v1"
+)
+
+func TestNodeReadiness(t *testing.T) {
+	readiness := &NodeReadiness{
+		Results: []*CheckResult{
+			{
+				Check: "ready",
+				Ready: true,
+			},
+			{
+				Check:    "optional",
+				Optional: true,
+				Reason:   "0/1 ready",
+			},
+			{
+				Check:    "required",
+				Blocking: true,
+				Reason:   "1/2 ready",
+			},
+		},
+	}
+
+	if readiness.Ready() {
+		t.Error("expected node to not be ready")
+	}
+
+	if len(readiness.OptionalFailures()) != 1 {
+		t.Errorf("expected 1 optional failure, got %d", len(readiness.OptionalFailures()))
+	}
+
+	expected := "optional: 0/1 ready; required: 1/2 ready"
+	if readiness.String() != expected {
+		t.Errorf("expected %s, got %s", expected, readiness.String())
+	}
+
+	readiness.Results[2].Blocking = false
+	if !readiness.Ready() {
+		t.Error("expected node to be ready")
+	}
+}
+
+type mockCheck struct {
+	name  string
+	ready bool
+}
+
+func (c *mockCheck) Name() string {
+	return c.name
+}
+
+func (c *mockCheck) Evaluate(ctx context.Context, node *v1.Node, snapshot *NodeSnapshot) (*CheckResult, error) {
+	var unmet []string
+	if !c.ready {
+		unmet = append(unmet, "not ready")
+	}
+	return newCheckResult(c, unmet), nil
+}
+
+func TestEvaluateChecks(t *testing.T) {
+	checks := []ReadinessCheck{
+		&mockCheck{name: "foo", ready: true},
+		&mockCheck{name: "bar", ready: false},
+	}
+
+	readiness, err := evaluateChecks(context.Background(), checks, &v1.Node{}, &NodeSnapshot{})
+	if err != nil {
+		t.Errorf("should not fail: %s", err)
+	}
+
+	if readiness.Ready() {
+		t.Error("expected node to not be ready")
+	}
+
+	if readiness.String() != "bar: not ready" {
+		t.Errorf("expected 'bar: not ready', got '%s'", readiness.String())
+	}
+}
+
+func TestReadConfigChecks(t *testing.T) {
+	const data = `checks:
+- type: pods
+  name: kube2iam
+  namespace: kube-system
+  labels:
+    application: kube2iam
+- type: nodePrerequisites
+  conditions:
+  - type: Ready
+- type: csiDrivers
+  drivers:
+  - name: ebs.csi.aws.com
+- type: tcpProbe
+  name: dns
+  port: 53
+- type: images
+  references:
+  - nginx:1.19`
+
+	config, err := ReadConfig(data)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	checks := config.ReadinessChecks()
+	if len(checks) != 5 {
+		t.Fatalf("expected %d checks, got %d", 5, len(checks))
+	}
+
+	expectedNames := []string{"kube2iam", "nodePrerequisites", "csiDrivers", "dns", "images"}
+	for i, name := range expectedNames {
+		if checks[i].Name() != name {
+			t.Errorf("expected check %s, got %s", name, checks[i].Name())
+		}
+	}
+
+	probe, ok := checks[3].(*Probe)
+	if !ok || probe.Type != ProbeTypeTCP {
+		t.Errorf("expected tcp probe, got %#v", checks[3])
+	}
+
+	nodePrerequisites, ok := checks[1].(*NodePrerequisites)
+	if !ok || len(nodePrerequisites.Conditions) != 1 || nodePrerequisites.Conditions[0].Type != v1.NodeReady {
+		t.Errorf("expected node prerequisites with Ready condition, got %#v", checks[1])
+	}
+
+	for _, invalid := range []string{
+		"checks:\n- type: unknown",
+		"checks:\n- type: tcpProbe\n  name: dns",
+	} {
+		_, err = ReadConfig(invalid)
+		if err == nil {
+			t.Errorf("expected error for config: %s", invalid)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	defaultStageName = "default"
+)
+
+// Stage is a readiness stage with its own taint. The taint of a stage is
+// removed once the checks of the stage and of all previous stages are ready.
+// The taint of an independent stage is removed once its own checks are ready
+// and it doesn't block the following stages.
+type Stage struct {
+	Name        string
+	Taint       string
+	Checks      []ReadinessCheck
+	Independent bool
+}
+
+// stageConfig is a readiness stage or a taint group defined in the config.
+type stageConfig struct {
+	Name      string         `yaml:"name"`
+	Taint     string         `yaml:"taint"`
+	Selectors []*PodSelector `yaml:"selectors"`
+	Checks    []checkConfig  `yaml:"checks"`
+}
+
+// stage returns the stage defined by the config.
+func (s *stageConfig) stage() *Stage {
+	checks := make([]ReadinessCheck, 0, len(s.Selectors)+len(s.Checks))
+	for _, selector := range s.Selectors {
+		checks = append(checks, selector)
+	}
+
+	for _, check := range s.Checks {
+		checks = append(checks, check.ReadinessCheck)
+	}
+
+	return &Stage{
+		Name:   s.Name,
+		Taint:  s.Taint,
+		Checks: checks,
+	}
+}
+
+// taintGroup returns the independent stage defined by a taint group in the
+// config. The name of the stage defaults to the taint.
+func (s *stageConfig) taintGroup() *Stage {
+	stage := s.stage()
+	if stage.Name == "" {
+		stage.Name = stage.Taint
+	}
+	stage.Independent = true
+	return stage
+}
+
+// validateStages validates that stages have a unique name and taint and that
+// their checks are valid.
+func validateStages(stages []*Stage) error {
+	names := make(map[string]struct{}, len(stages))
+	taints := make(map[string]struct{}, len(stages))
+	for _, stage := range stages {
+		if stage.Name == "" {
+			return fmt.Errorf("stage name must be specified")
+		}
+
+		if stage.Taint == "" {
+			return fmt.Errorf("taint must be specified for stage '%s'", stage.Name)
+		}
+
+		if _, ok := names[stage.Name]; ok {
+			return fmt.Errorf("duplicate stage '%s'", stage.Name)
+		}
+		names[stage.Name] = struct{}{}
+
+		if _, ok := taints[stage.Taint]; ok {
+			return fmt.Errorf("duplicate taint '%s' for stage '%s'", stage.Taint, stage.Name)
+		}
+		taints[stage.Taint] = struct{}{}
+
+		for _, check := range stage.Checks {
+			err := validateCheck(check)
+			if err != nil {
+				return fmt.Errorf("invalid check in stage '%s': %v", stage.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// StageReadiness is the result of evaluating the readiness of a stage.
+type StageReadiness struct {
+	Stage *Stage
+	// Readiness is the result of the checks of the stage. It's nil if the
+	// stage was not evaluated because a previous stage was not ready.
+	Readiness *NodeReadiness
+}
+
+// Ready returns true if the stage was evaluated and is ready.
+func (s *StageReadiness) Ready() bool {
+	return s.Readiness != nil && s.Readiness.Ready()
+}
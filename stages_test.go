@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReadConfigStages(t *testing.T) {
+	const data = `stages:
+- name: network
+  taint: network-not-ready
+  checks:
+  - type: pods
+    namespace: kube-system
+    labels:
+      application: cni
+taints:
+- taint: gpu-not-ready
+  selectors:
+  - namespace: kube-system
+    labels:
+      application: nvidia-device-plugin
+selectors:
+- namespace: kube-system
+  labels:
+    application: kube2iam`
+
+	config, err := ReadConfig(data)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	stages, err := config.ReadinessStages(taintNodeNotReadyName)
+	if err != nil {
+		t.Fatalf("should not fail: %s", err)
+	}
+
+	if len(stages) != 3 {
+		t.Fatalf("expected %d stages, got %d", 3, len(stages))
+	}
+
+	if stages[0].Name != "gpu-not-ready" || !stages[0].Independent || len(stages[0].Checks) != 1 {
+		t.Errorf("unexpected taint group: %#v", stages[0])
+	}
+
+	if stages[1].Name != "network" || stages[1].Taint != "network-not-ready" || len(stages[1].Checks) != 1 {
+		t.Errorf("unexpected first stage: %#v", stages[1])
+	}
+
+	if stages[2].Name != defaultStageName || stages[2].Taint != taintNodeNotReadyName || len(stages[2].Checks) != 1 {
+		t.Errorf("unexpected default stage: %#v", stages[2])
+	}
+
+	for _, data := range []string{
+		"stages:\n- name: network",
+		"stages:\n- taint: foo",
+		"stages:\n- name: a\n  taint: foo\n- name: b\n  taint: foo",
+		"stages:\n- name: a\n  taint: foo\n- name: a\n  taint: bar",
+		"taints:\n- taint: foo\nstages:\n- name: a\n  taint: foo",
+	} {
+		_, err := ReadConfig(data)
+		if err == nil {
+			t.Errorf("expected failure for config: %s", data)
+		}
+	}
+
+	// the implicit default stage must not conflict with the configured
+	// stages.
+	for _, tc := range []struct {
+		msg          string
+		data         string
+		defaultTaint string
+	}{
+		{
+			msg:          "stage taint equal to the default taint",
+			data:         "stages:\n- name: network\n  taint: foo\nselectors:\n- namespace: default",
+			defaultTaint: "foo",
+		},
+		{
+			msg:          "taint group equal to the default taint",
+			data:         "taints:\n- taint: foo\nselectors:\n- namespace: default",
+			defaultTaint: "foo",
+		},
+		{
+			msg:          "stage named like the default stage",
+			data:         "stages:\n- name: default\n  taint: foo\nselectors:\n- namespace: default",
+			defaultTaint: taintNodeNotReadyName,
+		},
+		{
+			msg:          "no default taint",
+			data:         "selectors:\n- namespace: default",
+			defaultTaint: "",
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			config, err := ReadConfig(tc.data)
+			if err != nil {
+				t.Fatalf("should not fail: %s", err)
+			}
+
+			_, err = config.ReadinessStages(tc.defaultTaint)
+			if err == nil {
+				t.Error("expected failure")
+			}
+		})
+	}
+}
+
+func TestHandleNodeStages(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "foo",
+		},
+		Spec: v1.NodeSpec{
+			Taints: []v1.Taint{
+				{Key: "network"},
+				{Key: "storage"},
+				{Key: "workload"},
+			},
+		},
+	}
+
+	ready := &PodSelector{
+		Namespace: "default",
+		Labels:    map[string]string{"foo": "bar"},
+	}
+
+	notReady := &PodSelector{
+		Namespace: "default",
+		Labels:    map[string]string{"foo": "baz"},
+	}
+
+	hook := &mockHook{}
+	controller := &NodeController{
+		Interface: setupMockKubernetes(t, node, nil),
+		stages: []*Stage{
+			{Name: "network", Taint: "network", Checks: []ReadinessCheck{ready}},
+			{Name: "storage", Taint: "storage", Checks: []ReadinessCheck{notReady}},
+			{Name: "workload", Taint: "workload", Checks: []ReadinessCheck{ready}},
+		},
+		nodeReadyHooks: []Hook{hook},
+	}
+
+	err := controller.handleNode(context.Background(), node)
+	if err != nil {
+		t.Errorf("should not fail: %s", err)
+	}
+
+	n, err := controller.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Errorf("should not fail: %s", err)
+	}
+
+	if hasTaint(n, "network") {
+		t.Error("expected taint of ready stage to be removed")
+	}
+
+	if !hasTaint(n, "storage") || !hasTaint(n, "workload") {
+		t.Error("expected taints of not ready stage and following stages to be kept")
+	}
+
+	if hook.triggered != 0 {
+		t.Errorf("expected hooks to not be triggered, got %d", hook.triggered)
+	}
+
+	if hook.notReady != 1 {
+		t.Errorf("expected hooks to be called for the not ready node once, got %d", hook.notReady)
+	}
+
+	controller.stages[1].Checks = []ReadinessCheck{ready}
+	err = controller.handleNode(context.Background(), n)
+	if err != nil {
+		t.Errorf("should not fail: %s", err)
+	}
+
+	n, err = controller.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Errorf("should not fail: %s", err)
+	}
+
+	if len(n.Spec.Taints) != 0 {
+		t.Errorf("expected all stage taints to be removed, got %v", n.Spec.Taints)
+	}
+
+	if hook.triggered != 1 {
+		t.Errorf("expected hooks to be triggered once, got %d", hook.triggered)
+	}
+
+	if hook.notReady != 1 {
+		t.Errorf("expected hooks to not be called for the ready node, got %d", hook.notReady)
+	}
+}
+
+type mockHook struct {
+	triggered int
+	notReady  int
+}
+
+func (h *mockHook) Name() string {
+	return "mock"
+}
+
+func (h *mockHook) Trigger(providerID string) error {
+	h.triggered++
+	return nil
+}
+
+func (h *mockHook) NotReady(node *v1.Node) error {
+	h.notReady++
+	return nil
+}
+
+func TestHandleNodeTaintGroups(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "foo",
+		},
+		Spec: v1.NodeSpec{
+			Taints: []v1.Taint{
+				{Key: "network"},
+				{Key: "foo"},
+			},
+		},
+	}
+
+	client := setupMockKubernetes(t, node, nil)
+	controller := &NodeController{
+		Interface: client,
+		stages: []*Stage{
+			{
+				Name:        "gpu",
+				Taint:       "gpu",
+				Independent: true,
+				Checks: []ReadinessCheck{
+					&PodSelector{Namespace: "default", Labels: map[string]string{"foo": "baz"}},
+				},
+			},
+			{
+				Name:        "network",
+				Taint:       "network",
+				Independent: true,
+				Checks: []ReadinessCheck{
+					&PodSelector{Namespace: "default", Labels: map[string]string{"foo": "bar"}},
+				},
+			},
+		},
+	}
+
+	client.(*fake.Clientset).ClearActions()
+
+	err := controller.handleNode(context.Background(), node)
+	if err != nil {
+		t.Errorf("should not fail: %s", err)
+	}
+
+	updates := 0
+	for _, action := range client.(*fake.Clientset).Actions() {
+		if action.GetVerb() == "update" {
+			updates++
+		}
+	}
+
+	if updates != 1 {
+		t.Errorf("expected taints to be reconciled in 1 update, got %d", updates)
+	}
+
+	n, err := controller.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Errorf("should not fail: %s", err)
+	}
+
+	if hasTaint(n, "network") || !hasTaint(n, "gpu") || !hasTaint(n, "foo") {
+		t.Errorf("expected taints gpu and foo, got %v", n.Spec.Taints)
+	}
+}
+
+func TestReconcileTaints(t *testing.T) {
+	taints, added, removed := reconcileTaints(
+		[]v1.Taint{{Key: "a"}, {Key: "b"}, {Key: "foo"}},
+		map[string]bool{"a": true, "b": false, "c": false, "d": true},
+	)
+
+	if len(added) != 1 || added[0] != "c" {
+		t.Errorf("expected taint c to be added, got %v", added)
+	}
+
+	if len(removed) != 1 || removed[0] != "a" {
+		t.Errorf("expected taint a to be removed, got %v", removed)
+	}
+
+	if len(taints) != 3 || taints[0].Key != "b" || taints[1].Key != "foo" || taints[2].Key != "c" {
+		t.Errorf("unexpected taints: %v", taints)
+	}
+}